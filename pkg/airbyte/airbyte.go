@@ -4,35 +4,200 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/ghodss/yaml"
-	"github.com/santhosh-tekuri/jsonschema/v5"
 	"go.uber.org/zap"
 )
 
+// AirbyteMessage types
+const (
+	AirbyteMessageTypeRecord           = "RECORD"
+	AirbyteMessageTypeState            = "STATE"
+	AirbyteMessageTypeLog              = "LOG"
+	AirbyteMessageTypeSpec             = "SPEC"
+	AirbyteMessageTypeConnectionStatus = "CONNECTION_STATUS"
+	AirbyteMessageTypeCatalog          = "CATALOG"
+	AirbyteMessageTypeTrace            = "TRACE"
+	AirbyteMessageTypeControl          = "CONTROL"
+)
+
 // AirbyteMessage defines the AirbyteMessage protocol  as in
 // https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml#L13-L49
 type AirbyteMessage struct {
-	Type   string                `json:"type"`
-	Record *AirbyteRecordMessage `json:"record"`
+	Type             string                   `json:"type"`
+	Log              *AirbyteLogMessage       `json:"log,omitempty"`
+	Spec             *ConnectorSpecification  `json:"spec,omitempty"`
+	ConnectionStatus *AirbyteConnectionStatus `json:"connectionStatus,omitempty"`
+	Catalog          *AirbyteCatalog          `json:"catalog,omitempty"`
+	Record           *AirbyteRecordMessage    `json:"record,omitempty"`
+	State            *AirbyteStateMessage     `json:"state,omitempty"`
+	Trace            *AirbyteTraceMessage     `json:"trace,omitempty"`
+	Control          *AirbyteControlMessage   `json:"control,omitempty"`
 }
 
-// AirbyteRecordMessage defines the RECORD type of AirbyteMessage, AirbyteRecordMessage, protocol as in (without namespace field)
+// AirbyteRecordMessage defines the RECORD type of AirbyteMessage, AirbyteRecordMessage, protocol as in
 // https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml#L50-L70
 type AirbyteRecordMessage struct {
+	Namespace string          `json:"namespace,omitempty"`
 	Stream    string          `json:"stream"`
 	Data      json.RawMessage `json:"data"`
 	EmittedAt int64           `json:"emitted_at"`
 }
 
+// AirbyteStateMessage types
+const (
+	AirbyteStateTypeLegacy = "LEGACY"
+	AirbyteStateTypeStream = "STREAM"
+	AirbyteStateTypeGlobal = "GLOBAL"
+)
+
+// AirbyteStateMessage defines the STATE type of AirbyteMessage, AirbyteStateMessage, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteStateMessage struct {
+	Type             string              `json:"type,omitempty"`
+	Stream           *AirbyteStreamState `json:"stream,omitempty"`
+	Global           *AirbyteGlobalState `json:"global,omitempty"`
+	Data             json.RawMessage     `json:"data,omitempty"`
+	SourceStats      *AirbyteStateStats  `json:"sourceStats,omitempty"`
+	DestinationStats *AirbyteStateStats  `json:"destinationStats,omitempty"`
+}
+
+// AirbyteStateStats defines the AirbyteStateStats protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteStateStats struct {
+	RecordCount float64 `json:"recordCount,omitempty"`
+}
+
+// AirbyteStreamState defines the AirbyteStreamState protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteStreamState struct {
+	StreamDescriptor StreamDescriptor `json:"stream_descriptor"`
+	StreamState      json.RawMessage  `json:"stream_state,omitempty"`
+}
+
+// AirbyteGlobalState defines the AirbyteGlobalState protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteGlobalState struct {
+	SharedState  json.RawMessage      `json:"shared_state,omitempty"`
+	StreamStates []AirbyteStreamState `json:"stream_states"`
+}
+
+// StreamDescriptor defines the StreamDescriptor protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type StreamDescriptor struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AirbyteLogMessage defines the LOG type of AirbyteMessage, AirbyteLogMessage, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteLogMessage struct {
+	Level      string `json:"level"`
+	Message    string `json:"message"`
+	StackTrace string `json:"stack_trace,omitempty"`
+}
+
+// AirbyteTraceMessage types
+const (
+	AirbyteTraceTypeError        = "ERROR"
+	AirbyteTraceTypeEstimate     = "ESTIMATE"
+	AirbyteTraceTypeStreamStatus = "STREAM_STATUS"
+)
+
+// AirbyteTraceMessage defines the TRACE type of AirbyteMessage, AirbyteTraceMessage, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteTraceMessage struct {
+	Type         string                           `json:"type"`
+	EmittedAt    float64                          `json:"emitted_at"`
+	Error        *AirbyteErrorTraceMessage        `json:"error,omitempty"`
+	Estimate     *AirbyteEstimateTraceMessage     `json:"estimate,omitempty"`
+	StreamStatus *AirbyteStreamStatusTraceMessage `json:"stream_status,omitempty"`
+}
+
+// AirbyteErrorTraceMessage failure types
+const (
+	FailureTypeSystemError    = "system_error"
+	FailureTypeConfigError    = "config_error"
+	FailureTypeTransientError = "transient_error"
+)
+
+// AirbyteErrorTraceMessage defines the error AirbyteTraceMessage protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteErrorTraceMessage struct {
+	Message         string `json:"message"`
+	InternalMessage string `json:"internal_message,omitempty"`
+	StackTrace      string `json:"stack_trace,omitempty"`
+	FailureType     string `json:"failure_type,omitempty"`
+}
+
+// AirbyteEstimateTraceMessage defines the estimate AirbyteTraceMessage protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteEstimateTraceMessage struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Namespace    string `json:"namespace,omitempty"`
+	RowEstimate  int64  `json:"row_estimate,omitempty"`
+	ByteEstimate int64  `json:"byte_estimate,omitempty"`
+}
+
+// AirbyteStreamStatusTraceMessage defines the stream status AirbyteTraceMessage protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteStreamStatusTraceMessage struct {
+	StreamDescriptor StreamDescriptor `json:"stream_descriptor"`
+	Status           string           `json:"status"`
+}
+
+// AirbyteControlMessage types
+const (
+	AirbyteControlTypeConnectorConfig = "CONNECTOR_CONFIG"
+)
+
+// AirbyteControlMessage defines the CONTROL type of AirbyteMessage, AirbyteControlMessage, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteControlMessage struct {
+	Type            string                                `json:"type"`
+	EmittedAt       float64                               `json:"emitted_at"`
+	ConnectorConfig *AirbyteControlConnectorConfigMessage `json:"connectorConfig,omitempty"`
+}
+
+// AirbyteControlConnectorConfigMessage defines the connector config AirbyteControlMessage protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteControlConnectorConfigMessage struct {
+	Config json.RawMessage `json:"config"`
+}
+
+// AirbyteConnectionStatus defines the CONNECTION_STATUS type of AirbyteMessage, AirbyteConnectionStatus, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type AirbyteConnectionStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ConnectorSpecification defines the SPEC type of AirbyteMessage, ConnectorSpecification, protocol as in
+// https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml
+type ConnectorSpecification struct {
+	ProtocolVersion               string          `json:"protocol_version,omitempty"`
+	DocumentationURL              string          `json:"documentationUrl,omitempty"`
+	ChangelogURL                  string          `json:"changelogUrl,omitempty"`
+	ConnectionSpecification       json.RawMessage `json:"connectionSpecification"`
+	SupportsIncremental           bool            `json:"supportsIncremental,omitempty"`
+	SupportsNormalization         bool            `json:"supportsNormalization,omitempty"`
+	SupportsDBT                   bool            `json:"supportsDBT,omitempty"`
+	SupportedDestinationSyncModes []string        `json:"supported_destination_sync_modes,omitempty"`
+	SupportsNamespaces            bool            `json:"supportsNamespaces,omitempty"`
+	AuthSpecification             json.RawMessage `json:"authSpecification,omitempty"`
+	AdvancedAuth                  json.RawMessage `json:"advanced_auth,omitempty"`
+}
+
 // AirbyteCatalog defines the AirbyteCatalog protocol as in:
 // https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml#L212-L222
 type AirbyteCatalog struct {
 	Streams []AirbyteStream `json:"streams"`
 }
 
-// AirbyteStream defines the AirbyteStream protocol as in (without namespace field):
+// AirbyteStream defines the AirbyteStream protocol as in:
 // https://github.com/airbytehq/airbyte/blob/master/airbyte-protocol/protocol-models/src/main/resources/airbyte_protocol/airbyte_protocol.yaml#L223-L260
 type AirbyteStream struct {
 	Name                    string          `json:"name"`
@@ -41,6 +206,7 @@ type AirbyteStream struct {
 	SourceDefinedCursor     bool            `json:"source_defined_cursor"`
 	DefaultCursorField      []string        `json:"default_cursor_field"`
 	SourceDefinedPrimaryKey [][]string      `json:"source_defined_primary_key"`
+	Namespace               string          `json:"namespace,omitempty"`
 }
 
 // ConfiguredAirbyteCatalog defines the ConfiguredAirbyteCatalog protocol as in:
@@ -56,12 +222,15 @@ type ConfiguredAirbyteStream struct {
 	SyncMode            string         `json:"sync_mode"`
 	CursorField         []string       `json:"cursor_field"`
 	DestinationSyncMode string         `json:"destination_sync_mode"`
-	PrimaryKey          []string       `json:"primary_key"`
+	PrimaryKey          [][]string     `json:"primary_key"`
 }
 
-// TaskOutputAirbyteCatalog stores the pre-defined task AirbyteCatalog
+// TaskOutputAirbyteCatalog stores the pre-defined task AirbyteCatalog, with one stream per task
 var TaskOutputAirbyteCatalog AirbyteCatalog
 
+// taskNames stores the VDP protocol tasks in the protocol order
+var taskNames []string
+
 // TODO: add this in vdp_protocol
 const dataSchema = `
 {
@@ -98,26 +267,236 @@ func InitAirbyteCatalog(logger *zap.Logger, vdpProtocolPath string) {
 		logger.Fatal(fmt.Sprintf("%#v\n", err.Error()))
 	}
 
-	compiler := jsonschema.NewCompiler()
-
-	err = compiler.AddResource("protocol.json", strings.NewReader(dataSchema))
-	if err != nil {
+	var protocol map[string]interface{}
+	if err := json.Unmarshal(jsonSchemaBytes, &protocol); err != nil {
 		logger.Fatal(fmt.Sprintf("%#v\n", err.Error()))
 	}
 
-	_, err = compiler.Compile("protocol.json")
-	if err != nil {
+	// Initialise TaskOutputAirbyteCatalog with one typed stream per task
+	taskNames = []string{}
+	TaskOutputAirbyteCatalog.Streams = []AirbyteStream{}
+	anyOf, _ := protocol["anyOf"].([]interface{})
+	for _, v := range anyOf {
+		required, _ := v.(map[string]interface{})["required"].([]interface{})
+		for _, task := range required {
+			taskName := task.(string)
+			streamSchema, err := taskStreamSchema(protocol, taskName)
+			if err != nil {
+				logger.Fatal(fmt.Sprintf("%#v\n", err.Error()))
+			}
+			taskNames = append(taskNames, taskName)
+			TaskOutputAirbyteCatalog.Streams = append(TaskOutputAirbyteCatalog.Streams, AirbyteStream{
+				Name:                taskName,
+				JSONSchema:          streamSchema,
+				SupportedSyncModes:  []string{"full_refresh", "incremental"},
+				SourceDefinedCursor: false,
+			})
+
+			// Add the row-per-object stream of the object-level tasks
+			if !isObjectTask(taskName) {
+				continue
+			}
+			objectStreamSchema, err := objectStreamSchema(streamSchema)
+			if err != nil {
+				logger.Fatal(fmt.Sprintf("%#v\n", err.Error()))
+			}
+			TaskOutputAirbyteCatalog.Streams = append(TaskOutputAirbyteCatalog.Streams, AirbyteStream{
+				Name:                objectStreamName(taskName),
+				JSONSchema:          objectStreamSchema,
+				SupportedSyncModes:  []string{"full_refresh", "incremental"},
+				SourceDefinedCursor: false,
+			})
+		}
+	}
+
+	// Add the stream of the DataPayloads failing the validation
+	TaskOutputAirbyteCatalog.Streams = append(TaskOutputAirbyteCatalog.Streams, AirbyteStream{
+		Name:                quarantineStreamName,
+		JSONSchema:          json.RawMessage(quarantineStreamSchema),
+		SupportedSyncModes:  []string{"full_refresh", "incremental"},
+		SourceDefinedCursor: false,
+	})
+
+	// Compile the schemas the DataPayloads and records are validated against
+	if err := compileSchemas(); err != nil {
 		logger.Fatal(fmt.Sprintf("%#v\n", err.Error()))
 	}
+}
+
+// objectStreamSchema builds the JSON schema of the row-per-object stream from
+// the task stream schema: each item of the task objects becomes a row with
+// its nested objects (e.g., bounding_box) flattened into prefixed columns
+func objectStreamSchema(streamSchema json.RawMessage) (json.RawMessage, error) {
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(streamSchema, &schema); err != nil {
+		return nil, err
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	objects, _ := properties[objectsField].(map[string]interface{})
+	items, ok := objects["items"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no %s items in the stream schema", objectsField)
+	}
+
+	objectProperties := map[string]interface{}{
+		"data_mapping_index": properties["data_mapping_index"],
+		"metadata":           properties["metadata"],
+		textsField:           properties[textsField],
+		audiosField:          properties[audiosField],
+		payloadImagesField:   properties[payloadImagesField],
+		objectIndexField: map[string]interface{}{
+			"description": "The index of the object in the task output",
+			"type":        "integer",
+		},
+	}
+	required := []interface{}{"data_mapping_index", objectIndexField}
+	flattenSchemaProperties(items, "", objectProperties, &required, true)
+
+	return json.Marshal(map[string]interface{}{
+		"$schema":     schema["$schema"],
+		"type":        "object",
+		"description": fmt.Sprintf("%s, one row per object", schema["description"]),
+		"properties":  objectProperties,
+		"required":    required,
+	})
+}
+
+// flattenSchemaProperties adds the properties of the object schema to
+// flattened, the properties of nested objects being prefixed by their key
+func flattenSchemaProperties(schema map[string]interface{}, prefix string, flattened map[string]interface{}, required *[]interface{}, parentRequired bool) {
+
+	isRequired := map[string]bool{}
+	for _, key := range toSlice(schema["required"]) {
+		isRequired[key.(string)] = true
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := []string{}
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		property, _ := properties[key].(map[string]interface{})
+		keyRequired := parentRequired && isRequired[key]
+		if property["type"] == "object" && property["properties"] != nil {
+			flattenSchemaProperties(property, prefix+key+"_", flattened, required, keyRequired)
+			continue
+		}
+		flattened[prefix+key] = property
+		if keyRequired {
+			*required = append(*required, prefix+key)
+		}
+	}
+}
+
+// taskStreamSchema builds the self-contained JSON schema of a task stream from
+// the task definition of the VDP protocol, with the DataPayload
+// data_mapping_index, metadata, texts, audios and images as extra columns
+func taskStreamSchema(protocol map[string]interface{}, taskName string) (json.RawMessage, error) {
+
+	properties, _ := protocol["properties"].(map[string]interface{})
+	definitions, _ := protocol["definitions"].(map[string]interface{})
+
+	task, ok := properties[taskName].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("task %s not found in the VDP protocol", taskName)
+	}
+	taskSchema, ok := resolveRefs(task, definitions).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid task %s definition", taskName)
+	}
 
-	// Initialise TaskOutputAirbyteCatalog.Streams[0]
-	TaskOutputAirbyteCatalog.Streams = []AirbyteStream{
-		{
-			Name:                "vdp",
-			JSONSchema:          jsonSchemaBytes,
-			SupportedSyncModes:  []string{"full_refresh", "incremental"},
-			SourceDefinedCursor: false,
+	streamProperties := map[string]interface{}{
+		"data_mapping_index": map[string]interface{}{
+			"type": "string",
 		},
+		"metadata": map[string]interface{}{
+			"type": "object",
+		},
+	}
+	taskProperties, _ := taskSchema["properties"].(map[string]interface{})
+	for key, value := range taskProperties {
+		streamProperties[key] = value
+	}
+	streamProperties[textsField] = map[string]interface{}{
+		"description": "The DataPayload texts",
+		"type":        "array",
+		"items":       map[string]interface{}{"type": "string"},
+	}
+	streamProperties[audiosField] = map[string]interface{}{
+		"description": "The DataPayload audios, in base64",
+		"type":        "array",
+		"items":       map[string]interface{}{"type": "string", "contentEncoding": "base64"},
+	}
+	streamProperties[payloadImagesField] = map[string]interface{}{
+		"description": "The DataPayload images, written with the inline or offload image policy",
+		"type":        "array",
+		"items":       imageSchema,
+	}
+
+	// The generated images are written in base64 or replaced by their record
+	// value depending on the image policy
+	if taskName == textToImageTask {
+		if images, ok := streamProperties[textToImageField].(map[string]interface{}); ok {
+			images["items"] = map[string]interface{}{
+				"anyOf": []interface{}{images["items"], imageSchema},
+			}
+		}
 	}
 
+	return json.Marshal(map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"type":        "object",
+		"description": taskSchema["description"],
+		"properties":  streamProperties,
+		"required":    append([]interface{}{"data_mapping_index"}, toSlice(taskSchema["required"])...),
+	})
+}
+
+// resolveRefs inlines the "#/definitions/..." references of the schema
+func resolveRefs(schema interface{}, definitions map[string]interface{}) interface{} {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		resolved := map[string]interface{}{}
+		if ref, ok := v["$ref"].(string); ok && strings.HasPrefix(ref, "#/definitions/") {
+			if def, ok := definitions[strings.TrimPrefix(ref, "#/definitions/")]; ok {
+				for key, value := range resolveRefs(def, definitions).(map[string]interface{}) {
+					resolved[key] = value
+				}
+			}
+		}
+		for key, value := range v {
+			if key == "$ref" {
+				continue
+			}
+			resolved[key] = resolveRefs(value, definitions)
+		}
+		return resolved
+	case []interface{}:
+		resolved := []interface{}{}
+		for _, value := range v {
+			resolved = append(resolved, resolveRefs(value, definitions))
+		}
+		return resolved
+	default:
+		return v
+	}
+}
+
+func toSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// getTaskStream returns the stream of the given name in TaskOutputAirbyteCatalog
+func getTaskStream(streamName string) (*AirbyteStream, error) {
+	for idx := range TaskOutputAirbyteCatalog.Streams {
+		if TaskOutputAirbyteCatalog.Streams[idx].Name == streamName {
+			return &TaskOutputAirbyteCatalog.Streams[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("no stream %s", streamName)
 }
@@ -0,0 +1,152 @@
+package airbyte
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// airbyteMessageFixtures are AirbyteMessages as emitted by the destinations
+var airbyteMessageFixtures = map[string]string{
+	"legacy state": `{
+		"type": "STATE",
+		"state": {"data": {"cursor": "2023-07-01"}}
+	}`,
+	"legacy typed state": `{
+		"type": "STATE",
+		"state": {"type": "LEGACY", "data": {"cursor": "2023-07-01"}}
+	}`,
+	"stream state": `{
+		"type": "STATE",
+		"state": {
+			"type": "STREAM",
+			"stream": {
+				"stream_descriptor": {"name": "classification", "namespace": "vdp"},
+				"stream_state": {"cursor": 42}
+			},
+			"sourceStats": {"recordCount": 10},
+			"destinationStats": {"recordCount": 10}
+		}
+	}`,
+	"global state": `{
+		"type": "STATE",
+		"state": {
+			"type": "GLOBAL",
+			"global": {
+				"shared_state": {"lsn": 1234},
+				"stream_states": [
+					{"stream_descriptor": {"name": "classification", "namespace": "vdp"}, "stream_state": {"cursor": 1}},
+					{"stream_descriptor": {"name": "detection"}}
+				]
+			}
+		}
+	}`,
+	"log": `{
+		"type": "LOG",
+		"log": {"level": "ERROR", "message": "connection refused", "stack_trace": "java.net.ConnectException"}
+	}`,
+	"error trace": `{
+		"type": "TRACE",
+		"trace": {
+			"type": "ERROR",
+			"emitted_at": 1690000000000.5,
+			"error": {
+				"message": "Could not connect",
+				"internal_message": "java.net.ConnectException",
+				"stack_trace": "at ...",
+				"failure_type": "config_error"
+			}
+		}
+	}`,
+	"estimate trace": `{
+		"type": "TRACE",
+		"trace": {
+			"type": "ESTIMATE",
+			"emitted_at": 1690000000000,
+			"estimate": {"name": "classification", "type": "STREAM", "namespace": "vdp", "row_estimate": 100, "byte_estimate": 2048}
+		}
+	}`,
+	"stream status trace": `{
+		"type": "TRACE",
+		"trace": {
+			"type": "STREAM_STATUS",
+			"emitted_at": 1690000000000,
+			"stream_status": {"stream_descriptor": {"name": "classification", "namespace": "vdp"}, "status": "COMPLETE"}
+		}
+	}`,
+	"control": `{
+		"type": "CONTROL",
+		"control": {
+			"type": "CONNECTOR_CONFIG",
+			"emitted_at": 1690000000000,
+			"connectorConfig": {"config": {"host": "localhost", "credentials": {"access_token": "refreshed"}}}
+		}
+	}`,
+	"connection status": `{
+		"type": "CONNECTION_STATUS",
+		"connectionStatus": {"status": "FAILED", "message": "Could not connect"}
+	}`,
+	"spec": `{
+		"type": "SPEC",
+		"spec": {
+			"protocol_version": "0.2.0",
+			"documentationUrl": "https://docs.airbyte.com/integrations/destinations/postgres",
+			"changelogUrl": "https://docs.airbyte.com/integrations/destinations/postgres#changelog",
+			"connectionSpecification": {"type": "object", "properties": {"password": {"type": "string", "airbyte_secret": true}}},
+			"supportsIncremental": true,
+			"supportsNormalization": true,
+			"supportsDBT": true,
+			"supported_destination_sync_modes": ["overwrite", "append", "append_dedup"],
+			"supportsNamespaces": true,
+			"authSpecification": {"auth_type": "oauth2.0"},
+			"advanced_auth": {"auth_flow_type": "oauth2.0"}
+		}
+	}`,
+	"record": `{
+		"type": "RECORD",
+		"record": {"namespace": "vdp", "stream": "classification", "data": {"category": "cat"}, "emitted_at": 1690000000000}
+	}`,
+}
+
+func TestAirbyteMessageRoundTrip(t *testing.T) {
+	for name, fixture := range airbyteMessageFixtures {
+		var message AirbyteMessage
+		if err := json.Unmarshal([]byte(fixture), &message); err != nil {
+			t.Errorf("%s: unmarshal error: %v", name, err)
+			continue
+		}
+		b, err := json.Marshal(&message)
+		if err != nil {
+			t.Errorf("%s: marshal error: %v", name, err)
+			continue
+		}
+
+		var want, got interface{}
+		if err := json.Unmarshal([]byte(fixture), &want); err != nil {
+			t.Fatalf("%s: invalid fixture: %v", name, err)
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("%s: invalid round trip: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip = %s, want %s", name, b, fixture)
+		}
+	}
+}
+
+func TestAirbyteMessageNamespaces(t *testing.T) {
+	var message AirbyteMessage
+	if err := json.Unmarshal([]byte(airbyteMessageFixtures["global state"]), &message); err != nil {
+		t.Fatal(err)
+	}
+	streamStates := message.State.Global.StreamStates
+	if len(streamStates) != 2 {
+		t.Fatalf("stream states = %v, want 2", streamStates)
+	}
+	if got := streamStates[0].StreamDescriptor; got.Name != "classification" || got.Namespace != "vdp" {
+		t.Errorf("stream descriptor = %+v, want vdp.classification", got)
+	}
+	if got := streamStates[1].StreamDescriptor.Namespace; got != "" {
+		t.Errorf("namespace = %s, want none", got)
+	}
+}
@@ -0,0 +1,256 @@
+package airbyte
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// ImageLoader is implemented by the runtimes able to load `docker save`
+// archives, e.g., the Docker runtime
+type ImageLoader interface {
+	// LoadImage loads the images of the archive
+	LoadImage(ctx context.Context, archive io.Reader) error
+}
+
+// MissingImage is an enabled definition whose image is not available to the
+// runtime
+type MissingImage struct {
+	DefinitionID string
+	Image        string
+}
+
+// LoadImages loads the images of the definitions from the `docker save`
+// archives of the ImageArchiveDir option, the archives being matched to the
+// definitions by the repository and tag of the images they hold. The images
+// already present are not loaded again. A loaded image has no repository
+// digest, hence the digest-pinned definitions run the image of their archive
+// by ID, the archives being trusted to hold the pinned images.
+func (c *Connector) LoadImages(logger *zap.Logger, uids []uuid.UUID) error {
+
+	if c.options.ImageArchiveDir == "" {
+		return fmt.Errorf("no image archive directory")
+	}
+	loader, ok := c.runtime.(ImageLoader)
+	if !ok {
+		return fmt.Errorf("the container runtime is not able to load images")
+	}
+
+	archives, err := imageArchives(logger, c.options.ImageArchiveDir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	loaded := map[string]bool{}
+	for _, uid := range uids {
+		def, err := c.GetConnectorDefinitionByUid(uid)
+		if err != nil {
+			logger.Warn(err.Error())
+			continue
+		}
+		// The archives may hold the images of the mirror or the original ones
+		fields := def.GetVendorAttributes().GetFields()
+		repository := fields["dockerRepository"].GetStringValue()
+		repoTag := fmt.Sprintf("%s:%s", c.mirrorRepository(repository), fields["dockerImageTag"].GetStringValue())
+		if _, ok := archives[repoTag]; !ok {
+			repoTag = fmt.Sprintf("%s:%s", repository, fields["dockerImageTag"].GetStringValue())
+		}
+
+		exists, err := c.runtime.ImageExists(ctx, c.imageName(def))
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		image, ok := archives[repoTag]
+		if !ok {
+			logger.Warn(fmt.Sprintf("no image archive holds %s of %s", repoTag, def.GetId()))
+			continue
+		}
+
+		// The image of a pinned definition may have been loaded by a
+		// previous process
+		pinned := c.pinnedImageName(def)
+		if pinned != "" && image.ID != "" {
+			exists, err := c.runtime.ImageExists(ctx, image.ID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				c.setLoadedImage(pinned, image.ID)
+				continue
+			}
+		}
+
+		// An archive may hold the images of several definitions
+		if !loaded[image.Path] {
+			logger.Info(fmt.Sprintf("load %s from %s", repoTag, image.Path))
+			if err := loadImageArchive(loader, image.Path); err != nil {
+				return fmt.Errorf("load image archive %s error: %w", image.Path, err)
+			}
+			loaded[image.Path] = true
+		}
+		if pinned != "" && image.ID != "" {
+			c.setLoadedImage(pinned, image.ID)
+		}
+	}
+	return nil
+}
+
+// setLoadedImage runs the image loaded by ID instead of the pinned image
+func (c *Connector) setLoadedImage(pinned string, id string) {
+	c.loadedImagesMu.Lock()
+	defer c.loadedImagesMu.Unlock()
+	c.loadedImages[pinned] = id
+}
+
+// loadedImage returns the ID of the image loaded for the pinned image
+func (c *Connector) loadedImage(pinned string) (string, bool) {
+	c.loadedImagesMu.RLock()
+	defer c.loadedImagesMu.RUnlock()
+	id, ok := c.loadedImages[pinned]
+	return id, ok
+}
+
+// MissingImages reports the enabled definitions whose image is not available
+// to the runtime, e.g., before the pipelines using them fail. The Kubernetes
+// runtime reports no missing image since the images are resolved by the
+// kubelet. The digest-pinned images loaded from the archives are only found
+// once LoadImages ran.
+func (c *Connector) MissingImages(ctx context.Context) ([]MissingImage, error) {
+	missing := []MissingImage{}
+	seen := map[string]bool{}
+	for _, def := range c.ListConnectorDefinitions() {
+		if def == nil || def.GetTombstone() || seen[def.GetUid()] {
+			continue
+		}
+		seen[def.GetUid()] = true
+
+		imageName := c.imageName(def)
+		exists, err := c.runtime.ImageExists(ctx, imageName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, MissingImage{DefinitionID: def.GetId(), Image: imageName})
+		}
+	}
+	return missing, nil
+}
+
+// archiveImage is an image of a `docker save` archive
+type archiveImage struct {
+	Path string
+	// ID is the image ID, i.e., the digest of its config, empty if the
+	// manifest does not name the config
+	ID string
+}
+
+// imageArchives returns the images of the archives of the directory by
+// repository and tag
+func imageArchives(logger *zap.Logger, dir string) (map[string]archiveImage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	archives := map[string]archiveImage{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		images, err := archiveImageIDs(path)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("skip image archive %s: %v", path, err))
+			continue
+		}
+		for repoTag, id := range images {
+			archives[repoTag] = archiveImage{Path: path, ID: id}
+		}
+	}
+	return archives, nil
+}
+
+// archiveImageIDs returns the IDs of the images held by a `docker save`
+// archive, gzipped or not, by repository and tag from its manifest.json
+func archiveImageIDs(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := decompressArchive(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no manifest.json")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Clean(header.Name) != "manifest.json" {
+			continue
+		}
+		manifest := []struct {
+			Config   string
+			RepoTags []string
+		}{}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json: %w", err)
+		}
+		images := map[string]string{}
+		for _, image := range manifest {
+			for _, repoTag := range image.RepoTags {
+				images[repoTag] = configImageID(image.Config)
+			}
+		}
+		return images, nil
+	}
+}
+
+// configImageID returns the image ID of a manifest config, e.g.,
+// <hex>.json or blobs/sha256/<hex>
+func configImageID(config string) string {
+	if config == "" {
+		return ""
+	}
+	return "sha256:" + strings.TrimSuffix(filepath.Base(config), ".json")
+}
+
+// decompressArchive returns a reader of the archive, which may be gzipped
+func decompressArchive(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// loadImageArchive streams the archive into the runtime
+func loadImageArchive(loader ImageLoader, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return loader.LoadImage(context.Background(), file)
+}
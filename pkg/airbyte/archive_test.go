@@ -0,0 +1,166 @@
+package airbyte
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// loaderRuntime is a fakeRuntime loading `docker save` archives, the loaded
+// images being found by repository and tag or by ID only, as with Docker
+type loaderRuntime struct {
+	*fakeRuntime
+
+	mu     sync.Mutex
+	images map[string]bool
+	loads  int
+}
+
+func (r *loaderRuntime) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.images[imageName], nil
+}
+
+func (r *loaderRuntime) LoadImage(ctx context.Context, archive io.Reader) error {
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			return err
+		}
+		if header.Name != "manifest.json" {
+			continue
+		}
+		manifest := []struct {
+			Config   string
+			RepoTags []string
+		}{}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.loads++
+		for _, image := range manifest {
+			r.images[configImageID(image.Config)] = true
+			for _, repoTag := range image.RepoTags {
+				r.images[repoTag] = true
+			}
+		}
+		return nil
+	}
+}
+
+// writeImageArchive writes a `docker save` archive holding the image
+func writeImageArchive(t *testing.T, path string, config string, repoTag string) {
+	t.Helper()
+	manifest, err := json.Marshal([]map[string]interface{}{{"Config": config, "RepoTags": []string{repoTag}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tw := tar.NewWriter(file)
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadImagesPinned(t *testing.T) {
+	dir := t.TempDir()
+	runtime := &loaderRuntime{fakeRuntime: &fakeRuntime{}, images: map[string]bool{}}
+	c := newTestConnector(t, runtime, ConnectorOptions{
+		ImageArchiveDir: dir,
+		PullPolicy:      PullPolicyNever,
+		ImageDigests:    map[string]string{"airbyte-destination-postgres": "sha256:pinned"},
+	})
+	def, err := c.GetConnectorDefinitionByUid(postgresDefUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := def.GetVendorAttributes().GetFields()
+	repoTag := fields["dockerRepository"].GetStringValue() + ":" + fields["dockerImageTag"].GetStringValue()
+	writeImageArchive(t, filepath.Join(dir, "postgres.tar"), "blobs/sha256/0123abcd", repoTag)
+
+	missing, err := c.MissingImages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasMissingImage(missing, def.GetId()) {
+		t.Fatalf("MissingImages() = %v, want the pinned image missing", missing)
+	}
+
+	if err := c.LoadImages(zap.NewNop(), []uuid.UUID{postgresDefUID}); err != nil {
+		t.Fatal(err)
+	}
+	// The loaded image has no repository digest, it is run by ID
+	if got := c.imageName(def); got != "sha256:0123abcd" {
+		t.Errorf("imageName() = %s, want the loaded image ID", got)
+	}
+	if err := c.pullImage(context.Background(), zap.NewNop(), c.imageName(def)); err != nil {
+		t.Errorf("pullImage() error = %v, want the loaded image present", err)
+	}
+	missing, err = c.MissingImages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasMissingImage(missing, def.GetId()) {
+		t.Errorf("MissingImages() = %v, want the pinned image loaded", missing)
+	}
+
+	// The loaded image is not loaded again, e.g., by another process
+	c = newTestConnector(t, runtime, ConnectorOptions{
+		ImageArchiveDir: dir,
+		PullPolicy:      PullPolicyNever,
+		ImageDigests:    map[string]string{"airbyte-destination-postgres": "sha256:pinned"},
+	})
+	if err := c.LoadImages(zap.NewNop(), []uuid.UUID{postgresDefUID}); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.loads != 1 {
+		t.Errorf("loads = %d, want 1", runtime.loads)
+	}
+	if got := c.imageName(def); got != "sha256:0123abcd" {
+		t.Errorf("imageName() = %s, want the loaded image ID", got)
+	}
+}
+
+func hasMissingImage(missing []MissingImage, id string) bool {
+	for _, image := range missing {
+		if image.DefinitionID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConfigImageID(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"0123abcd.json":         "sha256:0123abcd",
+		"blobs/sha256/0123abcd": "sha256:0123abcd",
+	}
+	for config, want := range tests {
+		if got := configImageID(config); got != want {
+			t.Errorf("configImageID(%q) = %q, want %q", config, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package airbyte
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// BlobStore stores the images offloaded from the DataPayloads
+type BlobStore interface {
+	// Put stores the content under the key and returns its URI
+	Put(ctx context.Context, key string, content []byte, contentType string) (string, error)
+}
+
+// LocalBlobStore stores the blobs in a local directory
+type LocalBlobStore struct {
+	dir string
+}
+
+// NewLocalBlobStore returns a BlobStore writing the blobs under dir
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{dir: absDir}, nil
+}
+
+// Put writes the content to the key path under the store directory and
+// returns its file URI
+func (s *LocalBlobStore) Put(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	blobPath := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(blobPath), os.ModePerm); err != nil {
+		return "", fmt.Errorf("unable to create folders for filepath %s: %w", blobPath, err)
+	}
+	if err := os.WriteFile(blobPath, content, 0644); err != nil {
+		return "", fmt.Errorf("unable to write blob %s: %w", blobPath, err)
+	}
+	return "file://" + filepath.ToSlash(blobPath), nil
+}
+
+// S3BlobStoreOptions configures the S3-compatible endpoint of a S3BlobStore
+type S3BlobStoreOptions struct {
+	// Endpoint is the host of the S3-compatible service, e.g., s3.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to the keys of the blobs
+	Prefix string
+	// Insecure disables TLS
+	Insecure bool
+}
+
+// S3BlobStore stores the blobs in a bucket of a S3-compatible service
+type S3BlobStore struct {
+	client  *minio.Client
+	options S3BlobStoreOptions
+}
+
+// NewS3BlobStore returns a BlobStore writing the blobs to an S3 bucket
+func NewS3BlobStore(options S3BlobStoreOptions) (*S3BlobStore, error) {
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.AccessKeyID, options.SecretAccessKey, ""),
+		Secure: !options.Insecure,
+		Region: options.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create S3 client: %w", err)
+	}
+	return &S3BlobStore{client: client, options: options}, nil
+}
+
+// Put uploads the content to the bucket and returns its s3 URI
+func (s *S3BlobStore) Put(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	objectName := path.Join(s.options.Prefix, key)
+	if _, err := s.client.PutObject(ctx, s.options.Bucket, objectName, bytes.NewReader(content), int64(len(content)),
+		minio.PutObjectOptions{ContentType: contentType}); err != nil {
+		return "", fmt.Errorf("unable to upload blob %s: %w", objectName, err)
+	}
+	return fmt.Sprintf("s3://%s/%s", s.options.Bucket, objectName), nil
+}
@@ -0,0 +1,584 @@
+package airbyte
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	dockerclient "github.com/docker/docker/client"
+)
+
+// DockerRuntime implements ContainerRuntime on top of a Docker daemon. The job
+// files inside a job mount are written to the local filesystem, hence their
+// paths must be visible to both the backend process and the container (e.g.,
+// through the shared VDP volume), the secret ones being owned by the
+// container user. The other files are copied into the container, which works
+// with remote daemons and docker-in-docker.
+type DockerRuntime struct {
+	logger *zap.Logger
+	client *dockerclient.Client
+}
+
+// NewDockerRuntime returns a ContainerRuntime backed by the given Docker client
+func NewDockerRuntime(logger *zap.Logger, client *dockerclient.Client) *DockerRuntime {
+	return &DockerRuntime{logger: logger, client: client}
+}
+
+// PullImage pulls the image from the registry. The JSON progress stream is
+// logged at debug level, once per layer status change.
+func (r *DockerRuntime) PullImage(ctx context.Context, imageName string, credential *RegistryCredential) error {
+	options := types.ImagePullOptions{}
+	if credential != nil {
+		auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      credential.Username,
+			Password:      credential.Password,
+			IdentityToken: credential.IdentityToken,
+		})
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = auth
+	}
+
+	out, err := r.client.ImagePull(ctx, imageName, options)
+	if err != nil {
+		return &ContainerError{Op: ContainerOpPull, Image: imageName, Err: err}
+	}
+	defer out.Close()
+
+	decoder := json.NewDecoder(out)
+	statuses := map[string]string{}
+	for {
+		msg := jsonmessage.JSONMessage{}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return &ContainerError{Op: ContainerOpPull, Image: imageName, Err: err}
+		}
+		// The pull errors are reported in the stream
+		if msg.Error != nil {
+			return &ContainerError{Op: ContainerOpPull, Image: imageName, Err: msg.Error}
+		}
+		if status, ok := statuses[msg.ID]; ok && status == msg.Status {
+			continue
+		}
+		statuses[msg.ID] = msg.Status
+
+		fields := []zap.Field{zap.String("ImageName", imageName), zap.String("Status", msg.Status)}
+		if msg.ID != "" {
+			fields = append(fields, zap.String("Layer", msg.ID))
+		}
+		if msg.Progress != nil && msg.Progress.Total > 0 {
+			fields = append(fields, zap.Int64("Current", msg.Progress.Current), zap.Int64("Total", msg.Progress.Total))
+		}
+		r.logger.Debug("image pull progress", fields...)
+	}
+	r.logger.Info(fmt.Sprintf("pulled image %s", imageName))
+	return nil
+}
+
+// LoadImage loads the images of a `docker save` archive, gzipped or not
+func (r *DockerRuntime) LoadImage(ctx context.Context, archive io.Reader) error {
+	resp, err := r.client.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !resp.JSON {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		msg := jsonmessage.JSONMessage{}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		// e.g., "Loaded image: airbyte/destination-csv:1.0.0"
+		if stream := strings.TrimSpace(msg.Stream); stream != "" {
+			r.logger.Info(stream)
+		}
+	}
+}
+
+// ImageExists reports whether the image is present on the Docker host
+func (r *DockerRuntime) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	if _, _, err := r.client.ImageInspectWithRaw(ctx, imageName); err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ImageDigest returns the repository digest of the local image, or its ID if
+// the image was not pulled from a registry
+func (r *DockerRuntime) ImageDigest(ctx context.Context, imageName string) (string, error) {
+	inspect, _, err := r.client.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0], nil
+	}
+	return inspect.ID, nil
+}
+
+// Run runs the job in a new container. If the job has a Stdin, the container
+// is attached and its outputs are demultiplexed into Stdout/Stderr while the
+// input is streamed; otherwise the container is waited for and its logs are
+// demultiplexed. A non-zero exit code is returned as an ExitError holding the
+// stderr tail.
+func (r *DockerRuntime) Run(ctx context.Context, job *ContainerJob) error {
+
+	attach := job.Stdin != nil
+	stdout, stderr := job.Stdout, job.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	stderrTail := &tailWriter{}
+	stderr = io.MultiWriter(stderr, stderrTail)
+
+	// The files outside the job mounts are copied into the container, e.g.,
+	// with a remote Docker daemon, the others are shared through the mounts
+	sharedFiles, copiedFiles := []ContainerFile{}, []ContainerFile{}
+	for _, file := range job.Files {
+		if isMounted(job.Mounts, file.Path) {
+			sharedFiles = append(sharedFiles, file)
+		} else {
+			copiedFiles = append(copiedFiles, file)
+		}
+	}
+
+	defer func() {
+		for _, file := range sharedFiles {
+			if _, err := os.Stat(file.Path); err == nil {
+				if err := os.Remove(file.Path); err != nil {
+					r.logger.Error(fmt.Sprintf("ImageName: %s, ContainerName: %s, Error: %v", job.Image, job.Name, err))
+				}
+			}
+		}
+	}()
+
+	resources, err := dockerResources(job.Resources)
+	if err != nil {
+		return err
+	}
+
+	// The shared secret files are readable by their owner only, hence owned
+	// by the container user
+	uid, gid := -1, -1
+	for _, file := range sharedFiles {
+		if file.Secret {
+			if uid, gid, err = r.sharedFileOwner(ctx, job); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for _, file := range sharedFiles {
+		if err := writeJobFile(file, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	securityOpt, err := dockerSecurityOpt(job.Security)
+	if err != nil {
+		return err
+	}
+	tmpfs := map[string]string{}
+	for _, path := range job.Security.TmpfsPaths {
+		tmpfs[path] = "rw,nosuid,nodev"
+	}
+
+	resp, err := r.client.ContainerCreate(ctx,
+		&container.Config{
+			Image:        job.Image,
+			AttachStdin:  attach,
+			AttachStdout: attach,
+			AttachStderr: attach,
+			OpenStdin:    attach,
+			StdinOnce:    attach,
+			Cmd:          job.Cmd,
+			Env:          job.Env,
+			User:         job.Security.User,
+		},
+		&container.HostConfig{
+			Mounts:         append(dockerMounts(job.Mounts), fileVolumes(copiedFiles)...),
+			Resources:      resources,
+			ReadonlyRootfs: job.Security.ReadOnlyRootFS,
+			Tmpfs:          tmpfs,
+			CapDrop:        job.Security.DropCapabilities,
+			SecurityOpt:    securityOpt,
+			NetworkMode:    container.NetworkMode(job.Security.NetworkMode),
+		},
+		nil, nil, job.Name)
+	if err != nil {
+		return &ContainerError{Op: ContainerOpCreate, Image: job.Image, Err: err}
+	}
+
+	// The container is removed even if the context is done
+	defer func() {
+		if err := r.client.ContainerRemove(context.Background(), resp.ID,
+			types.ContainerRemoveOptions{
+				RemoveVolumes: true,
+				Force:         true,
+			}); err != nil {
+			r.logger.Error(fmt.Sprintf("ImageName: %s, ContainerName: %s, Error: %v", job.Image, job.Name, err))
+		}
+	}()
+
+	// Kill the container as soon as the context is done, e.g., when the job
+	// timeout passes, since reading an attached container output does not
+	// observe the context
+	killed := make(chan struct{})
+	stopKill := context.AfterFunc(ctx, func() {
+		defer close(killed)
+		if err := r.client.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil && !errdefs.IsNotFound(err) && !errdefs.IsConflict(err) {
+			r.logger.Warn(fmt.Sprintf("ImageName: %s, ContainerName: %s, Error: %v", job.Image, job.Name, err))
+		}
+	})
+	defer func() {
+		if !stopKill() {
+			<-killed
+		}
+	}()
+
+	if err := r.copyFiles(ctx, resp.ID, copiedFiles); err != nil {
+		return err
+	}
+
+	if attach {
+		hijackedResp, err := r.client.ContainerAttach(ctx, resp.ID, types.ContainerAttachOptions{
+			Stdout: true,
+			Stderr: true,
+			Stdin:  true,
+			Stream: true,
+		})
+		if err != nil {
+			return &ContainerError{Op: ContainerOpStart, Image: job.Image, Err: err}
+		}
+		defer hijackedResp.Close()
+
+		if err := r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+			return &ContainerError{Op: ContainerOpStart, Image: job.Image, Err: err}
+		}
+
+		// Stream the input while reading the outputs so that a large input
+		// does not fill up the container buffers. The container is killed if
+		// the input fails, closing its standard input would commit the
+		// records read so far.
+		input := &inputReader{r: job.Stdin, stop: func() {
+			if err := r.client.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil && !errdefs.IsNotFound(err) && !errdefs.IsConflict(err) {
+				r.logger.Warn(fmt.Sprintf("ImageName: %s, ContainerName: %s, Error: %v", job.Image, job.Name, err))
+			}
+		}}
+		stdinErr := make(chan error, 1)
+		go func() {
+			if _, err := io.Copy(hijackedResp.Conn, input); err != nil {
+				stdinErr <- err
+				return
+			}
+			// Close the container standard input so that it reads EOF
+			stdinErr <- hijackedResp.CloseWrite()
+		}()
+
+		_, err = stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
+		// The outputs end when the container is killed, the input error
+		// explaining why
+		if err := input.Err(); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		exitCode, err := r.wait(ctx, resp.ID)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return &ExitError{Image: job.Image, Container: job.Name, ExitCode: exitCode, StderrTail: stderrTail.String()}
+		}
+		// The container exited successfully, the input error is reported
+		// only if the input already failed
+		select {
+		case err := <-stdinErr:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	if err := r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return &ContainerError{Op: ContainerOpStart, Image: job.Image, Err: err}
+	}
+
+	exitCode, err := r.wait(ctx, resp.ID)
+	if err != nil {
+		return err
+	}
+
+	out, err := r.client.ContainerLogs(ctx, resp.ID,
+		types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, out); err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return &ExitError{Image: job.Image, Container: job.Name, ExitCode: exitCode, StderrTail: stderrTail.String()}
+	}
+	return nil
+}
+
+// wait waits for the container to exit and returns its exit code
+func (r *DockerRuntime) wait(ctx context.Context, containerID string) (int64, error) {
+	statusCh, errCh := r.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return 0, err
+	case status := <-statusCh:
+		if status.Error != nil {
+			return 0, fmt.Errorf("wait container %s error: %s", containerID, status.Error.Message)
+		}
+		return status.StatusCode, nil
+	}
+}
+
+// copyFiles copies the files into the anonymous volumes of their directories,
+// which are writable even with a read-only root filesystem. The files are
+// owned by the container user.
+func (r *DockerRuntime) copyFiles(ctx context.Context, containerID string, files []ContainerFile) error {
+	for dir, dirFiles := range filesByDir(files) {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		for _, file := range dirFiles {
+			mode := int64(0644)
+			if file.Secret {
+				mode = 0600
+			}
+			if err := tw.WriteHeader(&tar.Header{
+				Name:    filepath.Base(file.Path),
+				Mode:    mode,
+				Size:    int64(len(file.Content)),
+				ModTime: time.Now(),
+			}); err != nil {
+				return err
+			}
+			if _, err := tw.Write(file.Content); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		if err := r.client.CopyToContainer(ctx, containerID, dir, &archive, types.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+			return fmt.Errorf("unable to copy container files into %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// sharedFileOwner returns the uid and gid the shared secret files are owned
+// by for the container user to read them, -1 keeping this process ones. The
+// container user is the security profile one, otherwise the image one.
+func (r *DockerRuntime) sharedFileOwner(ctx context.Context, job *ContainerJob) (int, int, error) {
+	user := job.Security.User
+	if user == "" {
+		image, _, err := r.client.ImageInspectWithRaw(ctx, job.Image)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unable to inspect image %s user: %w", job.Image, err)
+		}
+		if image.Config != nil {
+			user = image.Config.User
+		}
+	}
+	return fileOwner(user)
+}
+
+// fileOwner parses the uid[:gid] container user. A user name cannot be
+// resolved to the uid of the container, hence the root one only is accepted.
+func fileOwner(user string) (int, int, error) {
+	name, group, _ := strings.Cut(user, ":")
+	if name == "" || name == "root" || name == "0" {
+		return -1, -1, nil
+	}
+	uid, err := strconv.Atoi(name)
+	if err != nil {
+		return -1, -1, fmt.Errorf("the container user %s cannot read the job files shared through the VDP volume, set CopyJobFiles", user)
+	}
+	if uid == os.Getuid() {
+		uid = -1
+	}
+	gid := -1
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			return -1, -1, fmt.Errorf("the container group %s cannot read the job files shared through the VDP volume, set CopyJobFiles", group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// writeJobFile writes a job file, the secret files being readable by the
+// owner only, chowned to uid and gid unless -1. The folders of the secret
+// files are shared by the jobs, hence they can be traversed but not listed
+// by the container users.
+func writeJobFile(file ContainerFile, uid, gid int) error {
+	dirPerm, filePerm := os.ModePerm, os.FileMode(0644)
+	if file.Secret {
+		dirPerm, filePerm = 0711, 0600
+	}
+	if err := os.MkdirAll(filepath.Dir(file.Path), dirPerm); err != nil {
+		return fmt.Errorf("unable to create folders for filepath %s: %w", file.Path, err)
+	}
+	if file.Secret {
+		if err := os.Chmod(filepath.Dir(file.Path), dirPerm); err != nil {
+			return fmt.Errorf("unable to change container local folder %s mode: %w", filepath.Dir(file.Path), err)
+		}
+	}
+	if err := os.WriteFile(file.Path, file.Content, filePerm); err != nil {
+		return fmt.Errorf("unable to write container local file %s: %w", file.Path, err)
+	}
+	// The permissions of an existing file are not changed by os.WriteFile
+	if err := os.Chmod(file.Path, filePerm); err != nil {
+		return fmt.Errorf("unable to change container local file %s mode: %w", file.Path, err)
+	}
+	if file.Secret && (uid != -1 || gid != -1) {
+		if err := os.Chown(file.Path, uid, gid); err != nil {
+			return fmt.Errorf("unable to give the container user the job file %s, set CopyJobFiles: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// dockerResources converts the requirements into Docker resources: the CPU
+// limit into NanoCPUs, the CPU request into relative CPU shares, the memory
+// limit into a hard limit and the memory request into a soft limit
+func dockerResources(requirements ResourceRequirements) (container.Resources, error) {
+	resources := container.Resources{}
+	for _, r := range []struct {
+		value string
+		set   func(q resource.Quantity)
+	}{
+		{requirements.CPURequest, func(q resource.Quantity) { resources.CPUShares = q.MilliValue() * 1024 / 1000 }},
+		{requirements.CPULimit, func(q resource.Quantity) { resources.NanoCPUs = q.MilliValue() * 1000000 }},
+		{requirements.MemoryRequest, func(q resource.Quantity) { resources.MemoryReservation = q.Value() }},
+		{requirements.MemoryLimit, func(q resource.Quantity) { resources.Memory = q.Value() }},
+	} {
+		if r.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid resource requirement %s: %w", r.value, err)
+		}
+		r.set(quantity)
+	}
+	return resources, nil
+}
+
+// dockerSecurityOpt returns the security options of the profile, the
+// seccomp profile being passed by content as the Docker CLI does
+func dockerSecurityOpt(profile SecurityProfile) ([]string, error) {
+	securityOpt := []string{}
+	if profile.NoNewPrivileges {
+		securityOpt = append(securityOpt, "no-new-privileges:true")
+	}
+	switch profile.SeccompProfile {
+	case "":
+	case SeccompUnconfined:
+		securityOpt = append(securityOpt, "seccomp="+SeccompUnconfined)
+	default:
+		b, err := os.ReadFile(profile.SeccompProfile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read seccomp profile %s: %w", profile.SeccompProfile, err)
+		}
+		securityOpt = append(securityOpt, "seccomp="+string(b))
+	}
+	return securityOpt, nil
+}
+
+// isMounted reports whether the path is inside one of the mounts
+func isMounted(mounts []ContainerMount, path string) bool {
+	for _, m := range mounts {
+		if m.Source != "" && strings.HasPrefix(filepath.Clean(path), filepath.Clean(m.Target)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filesByDir groups the files by directory
+func filesByDir(files []ContainerFile) map[string][]ContainerFile {
+	dirs := map[string][]ContainerFile{}
+	for _, file := range files {
+		dir := filepath.Dir(file.Path)
+		dirs[dir] = append(dirs[dir], file)
+	}
+	return dirs
+}
+
+// fileVolumes returns an anonymous volume per directory of the files, removed
+// along with the container
+func fileVolumes(files []ContainerFile) []mount.Mount {
+	volumes := []mount.Mount{}
+	for dir := range filesByDir(files) {
+		volumes = append(volumes, mount.Mount{Type: mount.TypeVolume, Target: dir})
+	}
+	return volumes
+}
+
+func dockerMounts(mounts []ContainerMount) []mount.Mount {
+	dockerMounts := []mount.Mount{}
+	for _, m := range mounts {
+		mountType := mount.TypeVolume
+		if len(m.Source) > 0 && string(m.Source[0]) == "/" {
+			mountType = mount.TypeBind
+		}
+		dockerMounts = append(dockerMounts, mount.Mount{
+			Type:     mountType,
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return dockerMounts
+}
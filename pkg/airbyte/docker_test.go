@@ -0,0 +1,68 @@
+package airbyte
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestFileOwner(t *testing.T) {
+	tests := []struct {
+		user     string
+		wantUID  int
+		wantGID  int
+		wantErr  bool
+		selfUser bool
+	}{
+		{user: "", wantUID: -1, wantGID: -1},
+		{user: "root", wantUID: -1, wantGID: -1},
+		{user: "0:0", wantUID: -1, wantGID: -1},
+		{user: "1000", wantUID: 1000, wantGID: -1},
+		{user: "1000:2000", wantUID: 1000, wantGID: 2000},
+		{user: strconv.Itoa(os.Getuid()), wantUID: -1, wantGID: -1, selfUser: true},
+		{user: "airbyte", wantErr: true},
+		{user: "1000:airbyte", wantErr: true},
+	}
+	for _, tt := range tests {
+		if tt.selfUser && os.Getuid() == 0 {
+			continue
+		}
+		uid, gid, err := fileOwner(tt.user)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("fileOwner(%q) error = %v, wantErr %v", tt.user, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (uid != tt.wantUID || gid != tt.wantGID) {
+			t.Errorf("fileOwner(%q) = %d, %d, want %d, %d", tt.user, uid, gid, tt.wantUID, tt.wantGID)
+		}
+	}
+}
+
+func TestWriteJobFile(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	path := filepath.Join(t.TempDir(), "config", "job.json")
+	if err := writeJobFile(ContainerFile{Path: path, Content: []byte(`{}`), Secret: true}, 1000, 2000); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if info.Mode().Perm() != 0600 || stat.Uid != 1000 || stat.Gid != 2000 {
+		t.Errorf("config file = %v %d:%d, want 0600 1000:2000", info.Mode().Perm(), stat.Uid, stat.Gid)
+	}
+	// The folder shared by the jobs can be traversed but not listed
+	info, err = os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0711 {
+		t.Errorf("config folder = %v, want 0711", info.Mode().Perm())
+	}
+}
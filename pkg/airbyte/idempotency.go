@@ -0,0 +1,275 @@
+package airbyte
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	bolt "go.etcd.io/bbolt"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// IdempotencyStore records the outputs of the completed writes by
+// idempotency key
+type IdempotencyStore interface {
+	// Get returns the recorded outputs of the key, false if none
+	Get(key string) ([]byte, bool, error)
+	// Set records the outputs of the key
+	Set(key string, outputs []byte) error
+}
+
+// MemoryIdempotencyStore records the completed writes in memory, the records
+// expiring after the ttl
+type MemoryIdempotencyStore struct {
+	cache *bigcache.BigCache
+	ttl   time.Duration
+}
+
+// NewMemoryIdempotencyStore returns an in-memory IdempotencyStore keeping the
+// records for ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) (*MemoryIdempotencyStore, error) {
+	// bigcache does not check the life window on Get, the records are only
+	// evicted by the clean up, hence the record time is checked as well
+	config := bigcache.DefaultConfig(ttl)
+	config.CleanWindow = ttl
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryIdempotencyStore{cache: cache, ttl: ttl}, nil
+}
+
+func (s *MemoryIdempotencyStore) Get(key string) ([]byte, bool, error) {
+	v, err := s.cache.Get(key)
+	if errors.Is(err, bigcache.ErrEntryNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(v) < idempotencyTimestampSize || recordExpired(v, s.ttl) {
+		return nil, false, nil
+	}
+	return v[idempotencyTimestampSize:], true, nil
+}
+
+func (s *MemoryIdempotencyStore) Set(key string, outputs []byte) error {
+	return s.cache.Set(key, recordValue(outputs, time.Now()))
+}
+
+// Close stops the clean up of the expired records
+func (s *MemoryIdempotencyStore) Close() error {
+	return s.cache.Close()
+}
+
+var idempotencyBucket = []byte("idempotency")
+
+// idempotencyTimestampSize is the size of the record time prefixed to the
+// outputs in the stores
+const idempotencyTimestampSize = 8
+
+// recordValue prefixes the outputs with the record time
+func recordValue(outputs []byte, recorded time.Time) []byte {
+	v := make([]byte, idempotencyTimestampSize, idempotencyTimestampSize+len(outputs))
+	binary.BigEndian.PutUint64(v, uint64(recorded.UnixNano()))
+	return append(v, outputs...)
+}
+
+// recordExpired reports whether the record value is older than the ttl, a
+// zero ttl never expiring
+func recordExpired(v []byte, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	recorded := time.Unix(0, int64(binary.BigEndian.Uint64(v[:idempotencyTimestampSize])))
+	return time.Since(recorded) > ttl
+}
+
+// BoltIdempotencyStore records the completed writes in a bbolt file, hence
+// the records survive restarts. The records expiring after the ttl are
+// swept periodically, so that the file does not grow forever.
+type BoltIdempotencyStore struct {
+	db  *bolt.DB
+	ttl time.Duration
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// NewBoltIdempotencyStore opens, or creates, the bbolt file at path, keeping
+// the records for ttl. With a zero ttl the records never expire and the file
+// must be pruned by the operator.
+func NewBoltIdempotencyStore(path string, ttl time.Duration) (*BoltIdempotencyStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open idempotency store %s error: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(idempotencyBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltIdempotencyStore{db: db, ttl: ttl, stop: make(chan struct{})}
+	if ttl > 0 {
+		if _, err := s.Sweep(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		s.stopped.Add(1)
+		go s.sweepPeriodically()
+	}
+	return s, nil
+}
+
+func (s *BoltIdempotencyStore) Get(key string) ([]byte, bool, error) {
+	var outputs []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(idempotencyBucket).Get([]byte(key))
+		if v == nil || len(v) < idempotencyTimestampSize || recordExpired(v, s.ttl) {
+			return nil
+		}
+		// The value is only valid during the transaction
+		outputs = append([]byte{}, v[idempotencyTimestampSize:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return outputs, outputs != nil, nil
+}
+
+func (s *BoltIdempotencyStore) Set(key string, outputs []byte) error {
+	v := recordValue(outputs, time.Now())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Put([]byte(key), v)
+	})
+}
+
+// Sweep deletes the expired records and returns their number
+func (s *BoltIdempotencyStore) Sweep() (int, error) {
+	if s.ttl <= 0 {
+		return 0, nil
+	}
+	swept := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		// The keys are deleted once iterated, deleting during the iteration
+		// would skip some
+		expired := [][]byte{}
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) < idempotencyTimestampSize || recordExpired(v, s.ttl) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		swept = len(expired)
+		return nil
+	})
+	return swept, err
+}
+
+// sweepPeriodically sweeps the expired records every ttl until the store is
+// closed
+func (s *BoltIdempotencyStore) sweepPeriodically() {
+	defer s.stopped.Done()
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			// The records are still checked on Get if the sweep fails
+			_, _ = s.Sweep()
+		}
+	}
+}
+
+// Close stops the sweeps and closes the bbolt file
+func (s *BoltIdempotencyStore) Close() error {
+	close(s.stop)
+	s.stopped.Wait()
+	return s.db.Close()
+}
+
+// idempotencyKey derives the key of a write from the definition, the
+// connection config and options, and the content of each DataPayload
+func (con *Connection) idempotencyKey(inputs []*connectorPB.DataPayload) (string, error) {
+
+	hash := sha256.New()
+	hash.Write(con.defUid.Bytes())
+
+	config, err := proto.MarshalOptions{Deterministic: true}.Marshal(con.config)
+	if err != nil {
+		return "", err
+	}
+	configHash := sha256.Sum256(config)
+	hash.Write(configHash[:])
+
+	options, err := json.Marshal(con.options)
+	if err != nil {
+		return "", err
+	}
+	optionsHash := sha256.Sum256(options)
+	hash.Write(optionsHash[:])
+
+	for _, input := range inputs {
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(input)
+		if err != nil {
+			return "", err
+		}
+		payloadHash := sha256.Sum256(payload)
+		hash.Write(payloadHash[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// marshalOutputs marshals the outputs recorded in the IdempotencyStore
+func marshalOutputs(outputs []*connectorPB.DataPayload) ([]byte, error) {
+	messages := []json.RawMessage{}
+	for _, output := range outputs {
+		b, err := protojson.Marshal(output)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, b)
+	}
+	return json.Marshal(messages)
+}
+
+// unmarshalOutputs unmarshals the outputs recorded in the IdempotencyStore
+func unmarshalOutputs(b []byte) ([]*connectorPB.DataPayload, error) {
+	messages := []json.RawMessage{}
+	if err := json.Unmarshal(b, &messages); err != nil {
+		return nil, err
+	}
+	outputs := []*connectorPB.DataPayload{}
+	for _, message := range messages {
+		output := &connectorPB.DataPayload{}
+		if err := protojson.Unmarshal(message, output); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,120 @@
+package airbyte
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// setRecordedAt backdates the record of key in the bbolt file
+func setRecordedAt(t *testing.T, store *BoltIdempotencyStore, key string, recorded time.Time) {
+	t.Helper()
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		v := append([]byte{}, bucket.Get([]byte(key))...)
+		binary.BigEndian.PutUint64(v, uint64(recorded.UnixNano()))
+		return bucket.Put([]byte(key), v)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltIdempotencyStoreExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.db")
+	store, err := NewBoltIdempotencyStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for key, outputs := range map[string]string{"old": `[]`, "new": `[{}]`} {
+		if err := store.Set(key, []byte(outputs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if outputs, ok, err := store.Get("old"); err != nil || !ok || string(outputs) != `[]` {
+		t.Fatalf("Get(old) = %s, %v, %v, want the outputs", outputs, ok, err)
+	}
+
+	setRecordedAt(t, store, "old", time.Now().Add(-2*time.Hour))
+	if _, ok, err := store.Get("old"); err != nil || ok {
+		t.Errorf("Get(old) = %v, %v, want the record expired", ok, err)
+	}
+
+	swept, err := store.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept != 1 {
+		t.Errorf("Sweep() = %d, want 1", swept)
+	}
+	if outputs, ok, err := store.Get("new"); err != nil || !ok || string(outputs) != `[{}]` {
+		t.Errorf("Get(new) = %s, %v, %v, want the outputs", outputs, ok, err)
+	}
+}
+
+func TestBoltIdempotencyStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.db")
+	store, err := NewBoltIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("key", []byte(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The records survive restarts and never expire without ttl
+	store, err = NewBoltIdempotencyStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if outputs, ok, err := store.Get("key"); err != nil || !ok || string(outputs) != `[]` {
+		t.Errorf("Get(key) = %s, %v, %v, want the outputs", outputs, ok, err)
+	}
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	store, err := NewMemoryIdempotencyStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Set("new", []byte(`[{}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.cache.Set("old", recordValue([]byte(`[]`), time.Now().Add(-2*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.Get("old"); err != nil || ok {
+		t.Errorf("Get(old) = %v, %v, want the record expired", ok, err)
+	}
+	if outputs, ok, err := store.Get("new"); err != nil || !ok || string(outputs) != `[{}]` {
+		t.Errorf("Get(new) = %s, %v, %v, want the outputs", outputs, ok, err)
+	}
+}
+
+func TestExecuteWithEmptyIdempotencyKey(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error { return nil }}
+	con := newTestConnection(t, runtime)
+
+	// An empty key does not replay the outputs of another write
+	for _, idx := range []string{"01", "02", "01"} {
+		if _, err := con.ExecuteWithIdempotencyKey(context.Background(), "", []*connectorPB.DataPayload{testDataPayload(t, idx, classificationOutput)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs := len(runtime.Runs()); runs != 2 {
+		t.Errorf("runs = %d, want 2", runs)
+	}
+}
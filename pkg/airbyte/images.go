@@ -0,0 +1,178 @@
+package airbyte
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/http"
+	"strings"
+
+	// Register the decoders of the image dimensions
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Image policies applied to the DataPayload images and the text_to_image
+// output images
+const (
+	// imagePolicyDrop does not write the DataPayload images, the text_to_image
+	// output images being written as they are, i.e., in base64
+	imagePolicyDrop = "drop"
+	// imagePolicyInline writes the images in base64
+	imagePolicyInline = "inline"
+	// imagePolicyOffload uploads the images to the BlobStore and writes their URI
+	imagePolicyOffload = "offload"
+)
+
+const (
+	// payloadImagesField is the record field holding the DataPayload images
+	payloadImagesField = "payload_images"
+	textToImageTask    = "text_to_image"
+	textToImageField   = "images"
+)
+
+// imageSchema is the JSON schema of the record value of an image
+var imageSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"uri": map[string]interface{}{
+			"description": "The URI of the offloaded image",
+			"type":        "string",
+		},
+		"data": map[string]interface{}{
+			"description": "The inlined image in base64",
+			"type":        "string",
+		},
+		"sha256": map[string]interface{}{
+			"description": "The SHA-256 hash of the image content",
+			"type":        "string",
+		},
+		"mime_type": map[string]interface{}{
+			"type": "string",
+		},
+		"width": map[string]interface{}{
+			"type": "integer",
+		},
+		"height": map[string]interface{}{
+			"type": "integer",
+		},
+	},
+	"required": []interface{}{"sha256", "mime_type"},
+}
+
+// imageExtensions maps the detected MIME types to the blob key extensions
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// imageHandler applies the image policy of a connection
+type imageHandler struct {
+	policy string
+	store  BlobStore
+}
+
+// handle returns the record value of an image, i.e., its hash, MIME type and
+// dimensions, and either its content in base64 or the URI it is offloaded to
+func (h *imageHandler) handle(ctx context.Context, content []byte) (map[string]interface{}, error) {
+
+	hash := sha256.Sum256(content)
+	mimeType := http.DetectContentType(content)
+
+	value := map[string]interface{}{
+		"sha256":    hex.EncodeToString(hash[:]),
+		"mime_type": mimeType,
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		value["width"] = config.Width
+		value["height"] = config.Height
+	}
+
+	switch h.policy {
+	case imagePolicyInline:
+		value["data"] = base64.StdEncoding.EncodeToString(content)
+	case imagePolicyOffload:
+		// Images are keyed by content so that duplicates are stored once
+		key := fmt.Sprintf("images/%s%s", value["sha256"], imageExtensions[mimeType])
+		uri, err := h.store.Put(ctx, key, content, mimeType)
+		if err != nil {
+			return nil, err
+		}
+		value["uri"] = uri
+	}
+	return value, nil
+}
+
+// handlePayload returns the record values of the DataPayload images and a
+// copy of the DataPayload with the text_to_image output images replaced by
+// their record values. The DataPayload is returned as is if there is no
+// text_to_image output or with the drop policy.
+func (h *imageHandler) handlePayload(ctx context.Context, dataPayload *connectorPB.DataPayload) (*connectorPB.DataPayload, []interface{}, error) {
+
+	if h == nil || h.policy == imagePolicyDrop {
+		return dataPayload, nil, nil
+	}
+
+	payloadImages := []interface{}{}
+	for idx, content := range dataPayload.GetImages() {
+		value, err := h.handle(ctx, content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("image [%d] error: %w", idx, err)
+		}
+		payloadImages = append(payloadImages, value)
+	}
+
+	textToImage := dataPayload.GetStructuredData().GetFields()[textToImageTask].GetStructValue()
+	if textToImage == nil {
+		return dataPayload, payloadImages, nil
+	}
+
+	outputImages := []interface{}{}
+	for idx, v := range textToImage.GetFields()[textToImageField].GetListValue().GetValues() {
+		content, err := decodeBase64Image(v.GetStringValue())
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s image [%d] error: %w", textToImageTask, idx, err)
+		}
+		value, err := h.handle(ctx, content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s image [%d] error: %w", textToImageTask, idx, err)
+		}
+		outputImages = append(outputImages, value)
+	}
+	images, err := structpb.NewList(outputImages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataPayload = proto.Clone(dataPayload).(*connectorPB.DataPayload)
+	output := dataPayload.StructuredData.Fields[textToImageTask].GetStructValue()
+	if output.Fields == nil {
+		output.Fields = map[string]*structpb.Value{}
+	}
+	output.Fields[textToImageField] = structpb.NewListValue(images)
+
+	return dataPayload, payloadImages, nil
+}
+
+// decodeBase64Image decodes an image in base64, with or without data URI prefix
+func decodeBase64Image(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		if idx := strings.Index(s, ","); idx >= 0 {
+			s = s[idx+1:]
+		}
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,52 @@
+package airbyte
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// pngImage is a 1x1 PNG image in base64
+const pngImage = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+func TestImageHandlerPayload(t *testing.T) {
+	structuredData, err := structpb.NewStruct(map[string]interface{}{
+		textToImageTask: map[string]interface{}{
+			textToImageField: []interface{}{pngImage},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataPayload := &connectorPB.DataPayload{StructuredData: structuredData}
+
+	// The drop policy writes the text_to_image images as they are
+	dropped, payloadImages, err := (&imageHandler{policy: imagePolicyDrop}).handlePayload(context.Background(), dataPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := dropped.GetStructuredData().GetFields()[textToImageTask].GetStructValue().GetFields()[textToImageField].GetListValue().GetValues()
+	if len(images) != 1 || images[0].GetStringValue() != pngImage || len(payloadImages) != 0 {
+		t.Errorf("drop policy images = %v, payload images = %v, want the base64 image", images, payloadImages)
+	}
+
+	inlined, _, err := (&imageHandler{policy: imagePolicyInline}).handlePayload(context.Background(), dataPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = inlined.GetStructuredData().GetFields()[textToImageTask].GetStructValue().GetFields()[textToImageField].GetListValue().GetValues()
+	if len(images) != 1 {
+		t.Fatalf("inline policy images = %v, want 1 image", images)
+	}
+	value := images[0].GetStructValue().AsMap()
+	if value["data"] != pngImage || value["mime_type"] != "image/png" || value["width"] != 1.0 {
+		t.Errorf("inline policy image = %v", value)
+	}
+	// The input DataPayload is left unchanged
+	if got := dataPayload.GetStructuredData().GetFields()[textToImageTask].GetStructValue().GetFields()[textToImageField].GetListValue().GetValues()[0].GetStringValue(); got != pngImage {
+		t.Errorf("input image = %s, want it unchanged", got)
+	}
+}
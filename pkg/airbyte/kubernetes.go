@@ -0,0 +1,526 @@
+package airbyte
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const kubernetesContainerName = "destination"
+
+// kubernetesNetworkLabel is set to none on the Pods which must not reach the
+// network
+const kubernetesNetworkLabel = "connector-destination.instill.tech/network"
+
+// KubernetesRuntimeOptions defines the options of the Kubernetes runtime
+type KubernetesRuntimeOptions struct {
+	// Namespace where the Pods, Secrets and ConfigMaps are created
+	Namespace          string
+	ServiceAccountName string
+	ImagePullSecrets   []string
+	// PollInterval is the interval to poll the Pod status, defaults to 1s
+	PollInterval time.Duration
+	// NetworkPolicy names the NetworkPolicy of the Namespace isolating the
+	// Pods labelled connector-destination.instill.tech/network=none, which
+	// the operator must install since the label alone does not isolate them.
+	// Without it, the jobs requiring NetworkModeNone keep the network access
+	// and a warning is logged. The jobs fail if the NetworkPolicy is missing.
+	NetworkPolicy string
+}
+
+// KubernetesRuntime implements ContainerRuntime by running each job as a Pod.
+// The job files are passed through a Secret (credentials) and a ConfigMap,
+// and the job Stdin is streamed by attaching to the running Pod. Job mounts
+// are ignored as the VDP and Airbyte volumes are not shared with the cluster.
+type KubernetesRuntime struct {
+	logger    *zap.Logger
+	clientset kubernetes.Interface
+	options   KubernetesRuntimeOptions
+
+	// attach streams the job Stdin into the running Pod and copies the Pod
+	// outputs into the job Stdout/Stderr
+	attach func(ctx context.Context, pod *corev1.Pod, job *ContainerJob) error
+
+	// networkWarning logs once that no NetworkPolicy isolates the Pods
+	networkWarning sync.Once
+}
+
+// NewKubernetesRuntime returns a ContainerRuntime backed by the given
+// clientset. The REST config is used to attach to the Pods and can be nil
+// if the jobs do not use Stdin (e.g., with the fake clientset).
+func NewKubernetesRuntime(logger *zap.Logger, config *rest.Config, clientset kubernetes.Interface, options KubernetesRuntimeOptions) *KubernetesRuntime {
+	if options.Namespace == "" {
+		options.Namespace = metav1.NamespaceDefault
+	}
+	if options.PollInterval == 0 {
+		options.PollInterval = time.Second
+	}
+	r := &KubernetesRuntime{
+		logger:    logger,
+		clientset: clientset,
+		options:   options,
+	}
+	r.attach = func(ctx context.Context, pod *corev1.Pod, job *ContainerJob) error {
+		if config == nil {
+			return fmt.Errorf("unable to attach to pod %s: no REST config", pod.Name)
+		}
+		req := clientset.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(pod.Namespace).
+			Name(pod.Name).
+			SubResource("attach").
+			VersionedParams(&corev1.PodAttachOptions{
+				Container: kubernetesContainerName,
+				Stdin:     true,
+				Stdout:    job.Stdout != nil,
+				Stderr:    job.Stderr != nil,
+			}, scheme.ParameterCodec)
+		executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+		if err != nil {
+			return err
+		}
+		return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  job.Stdin,
+			Stdout: job.Stdout,
+			Stderr: job.Stderr,
+		})
+	}
+	return r
+}
+
+// PullImage is a no-op since the images are pulled by the kubelet, following
+// the job PullPolicy and with the ImagePullSecrets credentials
+func (r *KubernetesRuntime) PullImage(ctx context.Context, imageName string, credential *RegistryCredential) error {
+	return nil
+}
+
+// ImageExists returns true since the images are resolved by the kubelet
+func (r *KubernetesRuntime) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	return true, nil
+}
+
+// ImageDigest returns the image name if it is pinned by digest, the images
+// being resolved by the kubelet only
+func (r *KubernetesRuntime) ImageDigest(ctx context.Context, imageName string) (string, error) {
+	if strings.Contains(imageName, "@sha256:") {
+		return imageName, nil
+	}
+	return "", nil
+}
+
+// checkNetworkPolicy checks the NetworkPolicy isolating the Pods without
+// network exists, warning once if none is configured
+func (r *KubernetesRuntime) checkNetworkPolicy(ctx context.Context) error {
+	if r.options.NetworkPolicy == "" {
+		r.networkWarning.Do(func() {
+			r.logger.Warn(fmt.Sprintf("no NetworkPolicy isolates the Pods labelled %s=%s, they keep the network access", kubernetesNetworkLabel, NetworkModeNone))
+		})
+		return nil
+	}
+	if _, err := r.clientset.NetworkingV1().NetworkPolicies(r.options.Namespace).Get(ctx, r.options.NetworkPolicy, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("unable to get NetworkPolicy %s isolating the Pods without network: %w", r.options.NetworkPolicy, err)
+	}
+	return nil
+}
+
+// Run runs the job as a Pod and waits for its completion, a failed Pod being
+// returned as an ExitError
+func (r *KubernetesRuntime) Run(ctx context.Context, job *ContainerJob) error {
+
+	pods := r.clientset.CoreV1().Pods(r.options.Namespace)
+
+	if job.Security.NetworkMode == NetworkModeNone {
+		if err := r.checkNetworkPolicy(ctx); err != nil {
+			return err
+		}
+	}
+
+	volumes, volumeMounts, err := r.createFiles(ctx, job)
+	defer r.deleteFiles(job)
+	if err != nil {
+		return err
+	}
+
+	resources, err := kubernetesResources(job.Resources)
+	if err != nil {
+		return err
+	}
+
+	securityContext, err := kubernetesSecurityContext(job.Security)
+	if err != nil {
+		return err
+	}
+	for idx, path := range job.Security.TmpfsPaths {
+		name := fmt.Sprintf("tmpfs-%d", idx)
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: name, MountPath: path})
+	}
+
+	// The NetworkPolicy option selecting this label isolates the Pod
+	labels := kubernetesLabels()
+	if job.Security.NetworkMode == NetworkModeNone {
+		labels[kubernetesNetworkLabel] = NetworkModeNone
+	}
+
+	imagePullSecrets := []corev1.LocalObjectReference{}
+	for _, name := range r.options.ImagePullSecrets {
+		imagePullSecrets = append(imagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	pod, err := pods.Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: r.options.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:      corev1.RestartPolicyNever,
+			ServiceAccountName: r.options.ServiceAccountName,
+			ImagePullSecrets:   imagePullSecrets,
+			Volumes:            volumes,
+			Containers: []corev1.Container{
+				{
+					Name:            kubernetesContainerName,
+					Image:           job.Image,
+					Args:            job.Cmd,
+					ImagePullPolicy: corev1.PullPolicy(job.PullPolicy),
+					Env:             kubernetesEnv(job.Env),
+					Stdin:           job.Stdin != nil,
+					StdinOnce:       job.Stdin != nil,
+					Resources:       resources,
+					VolumeMounts:    volumeMounts,
+					SecurityContext: securityContext,
+					// The termination message of a failed container holds
+					// the tail of its logs
+					TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return &ContainerError{Op: ContainerOpCreate, Image: job.Image, Err: err}
+	}
+
+	// The Pod is deleted even if the context is done, without grace period
+	// in that case so that its container is killed right away
+	defer func() {
+		deleteOptions := metav1.DeleteOptions{}
+		if ctx.Err() != nil {
+			gracePeriod := int64(0)
+			deleteOptions.GracePeriodSeconds = &gracePeriod
+		}
+		// The Pod may already be deleted if the input failed
+		if err := pods.Delete(context.Background(), pod.Name, deleteOptions); err != nil && !apierrors.IsNotFound(err) {
+			r.logger.Error(fmt.Sprintf("ImageName: %s, PodName: %s, Error: %v", job.Image, pod.Name, err))
+		}
+	}()
+
+	if job.Stdin != nil {
+		// The phase is empty until the Pod is scheduled
+		running, err := r.waitPod(ctx, pod.Name, func(phase corev1.PodPhase) bool {
+			return phase != "" && phase != corev1.PodPending
+		})
+		if err != nil {
+			return err
+		}
+		if running.Status.Phase == corev1.PodRunning {
+			// The Pod is deleted if the input fails, closing its standard
+			// input would commit the records read so far
+			input := &inputReader{r: job.Stdin, stop: func() {
+				gracePeriod := int64(0)
+				if err := pods.Delete(context.Background(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod}); err != nil {
+					r.logger.Warn(fmt.Sprintf("ImageName: %s, PodName: %s, Error: %v", job.Image, pod.Name, err))
+				}
+			}}
+			attachJob := *job
+			attachJob.Stdin = input
+			err := r.attach(ctx, running, &attachJob)
+			if err := input.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				return err
+			}
+		} else if running.Status.Phase == corev1.PodSucceeded {
+			// A failed Pod is returned as an ExitError below
+			return fmt.Errorf("pod %s completed before its standard input was attached", pod.Name)
+		}
+	}
+
+	completed, err := r.waitPod(ctx, pod.Name, func(phase corev1.PodPhase) bool {
+		return phase == corev1.PodSucceeded || phase == corev1.PodFailed
+	})
+	if err != nil {
+		return err
+	}
+
+	if job.Stdin == nil && job.Stdout != nil {
+		logs, err := pods.GetLogs(pod.Name, &corev1.PodLogOptions{Container: kubernetesContainerName}).Stream(ctx)
+		if err != nil {
+			return err
+		}
+		defer logs.Close()
+		if _, err := io.Copy(job.Stdout, logs); err != nil {
+			return err
+		}
+	}
+
+	if completed.Status.Phase == corev1.PodFailed {
+		exitErr := &ExitError{Image: job.Image, Container: pod.Name, ExitCode: -1}
+		for _, status := range completed.Status.ContainerStatuses {
+			if terminated := status.State.Terminated; terminated != nil {
+				exitErr.ExitCode = int64(terminated.ExitCode)
+				exitErr.StderrTail = strings.TrimSpace(terminated.Message)
+			}
+		}
+		return exitErr
+	}
+	return nil
+}
+
+// waitPod polls the Pod until its phase satisfies the condition
+func (r *KubernetesRuntime) waitPod(ctx context.Context, name string, condition func(corev1.PodPhase) bool) (*corev1.Pod, error) {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		pod, err := r.clientset.CoreV1().Pods(r.options.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if condition(pod.Status.Phase) {
+			return pod, nil
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if waiting := status.State.Waiting; waiting != nil {
+				switch waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff":
+					return nil, &ContainerError{
+						Op:    ContainerOpPull,
+						Image: status.Image,
+						Err:   fmt.Errorf("pod %s is not able to start: %s %s", name, waiting.Reason, waiting.Message),
+					}
+				case "InvalidImageName", "CreateContainerConfigError":
+					return nil, fmt.Errorf("pod %s is not able to start: %s %s", name, waiting.Reason, waiting.Message)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// createFiles creates the Secret and the ConfigMap holding the job files and
+// returns the volumes mounting them, one per file directory
+func (r *KubernetesRuntime) createFiles(ctx context.Context, job *ContainerJob) ([]corev1.Volume, []corev1.VolumeMount, error) {
+
+	if len(job.Files) == 0 {
+		return nil, nil, nil
+	}
+
+	secretData := map[string][]byte{}
+	configMapData := map[string][]byte{}
+	projections := map[string][]corev1.VolumeProjection{}
+	for idx, file := range job.Files {
+		key := fmt.Sprintf("file-%d", idx)
+		items := []corev1.KeyToPath{{Key: key, Path: filepath.Base(file.Path)}}
+		dir := filepath.Dir(file.Path)
+		if file.Secret {
+			secretData[key] = file.Content
+			projections[dir] = append(projections[dir], corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: job.Name},
+					Items:                items,
+				},
+			})
+		} else {
+			configMapData[key] = file.Content
+			projections[dir] = append(projections[dir], corev1.VolumeProjection{
+				ConfigMap: &corev1.ConfigMapProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: job.Name},
+					Items:                items,
+				},
+			})
+		}
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      job.Name,
+		Namespace: r.options.Namespace,
+		Labels:    kubernetesLabels(),
+	}
+	if len(secretData) > 0 {
+		if _, err := r.clientset.CoreV1().Secrets(r.options.Namespace).Create(ctx, &corev1.Secret{
+			ObjectMeta: meta,
+			Data:       secretData,
+		}, metav1.CreateOptions{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(configMapData) > 0 {
+		if _, err := r.clientset.CoreV1().ConfigMaps(r.options.Namespace).Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: meta,
+			BinaryData: configMapData,
+		}, metav1.CreateOptions{}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dirs := []string{}
+	for dir := range projections {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	volumes := []corev1.Volume{}
+	volumeMounts := []corev1.VolumeMount{}
+	for idx, dir := range dirs {
+		name := fmt.Sprintf("files-%d", idx)
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{Sources: projections[dir]},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: dir,
+			ReadOnly:  true,
+		})
+	}
+	return volumes, volumeMounts, nil
+}
+
+// deleteFiles deletes the Secret and the ConfigMap of the job, if any
+func (r *KubernetesRuntime) deleteFiles(job *ContainerJob) {
+	hasSecret, hasConfigMap := false, false
+	for _, file := range job.Files {
+		hasSecret = hasSecret || file.Secret
+		hasConfigMap = hasConfigMap || !file.Secret
+	}
+	if hasSecret {
+		if err := r.clientset.CoreV1().Secrets(r.options.Namespace).Delete(context.Background(), job.Name, metav1.DeleteOptions{}); err != nil {
+			r.logger.Warn(fmt.Sprintf("ImageName: %s, SecretName: %s, Error: %v", job.Image, job.Name, err))
+		}
+	}
+	if hasConfigMap {
+		if err := r.clientset.CoreV1().ConfigMaps(r.options.Namespace).Delete(context.Background(), job.Name, metav1.DeleteOptions{}); err != nil {
+			r.logger.Warn(fmt.Sprintf("ImageName: %s, ConfigMapName: %s, Error: %v", job.Image, job.Name, err))
+		}
+	}
+}
+
+func kubernetesLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "connector-destination",
+		"app.kubernetes.io/component":  vendorName,
+	}
+}
+
+// kubernetesSecurityContext converts the security profile into the Pod
+// container one
+func kubernetesSecurityContext(profile SecurityProfile) (*corev1.SecurityContext, error) {
+
+	securityContext := &corev1.SecurityContext{}
+	if profile.ReadOnlyRootFS {
+		securityContext.ReadOnlyRootFilesystem = &profile.ReadOnlyRootFS
+	}
+	if profile.NoNewPrivileges {
+		allowPrivilegeEscalation := false
+		securityContext.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+	}
+	if len(profile.DropCapabilities) > 0 {
+		securityContext.Capabilities = &corev1.Capabilities{}
+		for _, capability := range profile.DropCapabilities {
+			securityContext.Capabilities.Drop = append(securityContext.Capabilities.Drop, corev1.Capability(capability))
+		}
+	}
+	if profile.User != "" {
+		uid, gid, hasGid := strings.Cut(profile.User, ":")
+		runAsUser, err := strconv.ParseInt(uid, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user %s: %w", profile.User, err)
+		}
+		securityContext.RunAsUser = &runAsUser
+		if hasGid {
+			runAsGroup, err := strconv.ParseInt(gid, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user %s: %w", profile.User, err)
+			}
+			securityContext.RunAsGroup = &runAsGroup
+		}
+	}
+	switch profile.SeccompProfile {
+	case "":
+	case SeccompUnconfined:
+		securityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+	default:
+		securityContext.SeccompProfile = &corev1.SeccompProfile{
+			Type:             corev1.SeccompProfileTypeLocalhost,
+			LocalhostProfile: &profile.SeccompProfile,
+		}
+	}
+	return securityContext, nil
+}
+
+// kubernetesEnv converts the KEY=value environment variables
+func kubernetesEnv(env []string) []corev1.EnvVar {
+	vars := []corev1.EnvVar{}
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		vars = append(vars, corev1.EnvVar{Name: name, Value: value})
+	}
+	return vars
+}
+
+// kubernetesResources converts the job resources into the Pod container ones
+func kubernetesResources(requirements ResourceRequirements) (corev1.ResourceRequirements, error) {
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, r := range []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{resources.Requests, corev1.ResourceCPU, requirements.CPURequest},
+		{resources.Limits, corev1.ResourceCPU, requirements.CPULimit},
+		{resources.Requests, corev1.ResourceMemory, requirements.MemoryRequest},
+		{resources.Limits, corev1.ResourceMemory, requirements.MemoryLimit},
+	} {
+		if r.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return resources, fmt.Errorf("invalid resource requirement %s %s: %w", r.name, r.value, err)
+		}
+		r.list[r.name] = quantity
+	}
+	return resources, nil
+}
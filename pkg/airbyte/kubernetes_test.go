@@ -0,0 +1,338 @@
+package airbyte
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testNamespace = "vdp"
+
+// newTestKubernetesRuntime returns a runtime backed by a fake clientset whose
+// attach function is the given one
+func newTestKubernetesRuntime(attach func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error) (*KubernetesRuntime, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset()
+	r := NewKubernetesRuntime(zap.NewNop(), nil, clientset, KubernetesRuntimeOptions{
+		Namespace:        testNamespace,
+		ImagePullSecrets: []string{"registry"},
+		PollInterval:     5 * time.Millisecond,
+	})
+	r.attach = func(ctx context.Context, pod *corev1.Pod, job *ContainerJob) error {
+		return attach(r, pod, job)
+	}
+	return r, clientset
+}
+
+// setPodStatus sets the status of the Pod once created
+func setPodStatus(t *testing.T, clientset kubernetes.Interface, name string, status corev1.PodStatus) {
+	go func() {
+		for {
+			pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+			if err == nil {
+				pod.Status = status
+				if _, err := clientset.CoreV1().Pods(testNamespace).UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+}
+
+// terminatedStatus is the status of a Pod whose container exited
+func terminatedStatus(phase corev1.PodPhase, exitCode int32, message string) corev1.PodStatus {
+	return corev1.PodStatus{
+		Phase: phase,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name: kubernetesContainerName,
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Message: message},
+			},
+		}},
+	}
+}
+
+func testKubernetesJob(stdin io.Reader, stdout io.Writer) *ContainerJob {
+	return &ContainerJob{
+		Name:  "job",
+		Image: "airbyte/destination-postgres:0.3.27",
+		Cmd:   []string{"write", "--config", "/vdp/config/job.json", "--catalog", "/vdp/catalog/job.json"},
+		Files: []ContainerFile{
+			{Path: "/vdp/config/job.json", Content: []byte(`{"password": "secret"}`), Secret: true},
+			{Path: "/vdp/catalog/job.json", Content: []byte(`{"streams": []}`)},
+		},
+		Env: []string{"JAVA_OPTS=-Xmx768m"},
+		Resources: ResourceRequirements{
+			CPURequest:    "500m",
+			CPULimit:      "1",
+			MemoryRequest: "512Mi",
+			MemoryLimit:   "1Gi",
+		},
+		Security:   RestrictedSecurityProfile,
+		PullPolicy: PullPolicyIfNotPresent,
+		Stdin:      stdin,
+		Stdout:     stdout,
+	}
+}
+
+func TestKubernetesRuntimeRun(t *testing.T) {
+	var stdin bytes.Buffer
+	var created *corev1.Pod
+	r, clientset := newTestKubernetesRuntime(func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error {
+		created = pod
+		// The Secret and the ConfigMap exist while the Pod runs
+		secret, err := r.clientset.CoreV1().Secrets(testNamespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if got := string(secret.Data["file-0"]); got != `{"password": "secret"}` {
+			t.Errorf("Secret data = %s", got)
+		}
+		configMap, err := r.clientset.CoreV1().ConfigMaps(testNamespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if got := string(configMap.BinaryData["file-1"]); got != `{"streams": []}` {
+			t.Errorf("ConfigMap data = %s", got)
+		}
+
+		if _, err := io.Copy(&stdin, job.Stdin); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(job.Stdout, `{"type": "STATE", "state": {"data": {}}}`+"\n"); err != nil {
+			return err
+		}
+		setPodStatus(t, r.clientset, pod.Name, terminatedStatus(corev1.PodSucceeded, 0, ""))
+		return nil
+	})
+	setPodStatus(t, clientset, "job", corev1.PodStatus{Phase: corev1.PodRunning})
+
+	var stdout bytes.Buffer
+	if err := r.Run(context.Background(), testKubernetesJob(strings.NewReader("records\n"), &stdout)); err != nil {
+		t.Fatal(err)
+	}
+	if stdin.String() != "records\n" {
+		t.Errorf("stdin = %q, want the records", stdin.String())
+	}
+	if !strings.Contains(stdout.String(), "STATE") {
+		t.Errorf("stdout = %q, want the STATE message", stdout.String())
+	}
+	if created == nil {
+		t.Fatal("the Pod was not attached")
+	}
+
+	// Files: one projected volume per directory
+	spec := created.Spec
+	if len(spec.Volumes) != 3 {
+		t.Fatalf("volumes = %v, want 2 projected and 1 tmpfs", spec.Volumes)
+	}
+	projected := map[string]corev1.VolumeProjection{}
+	container := spec.Containers[0]
+	for _, volumeMount := range container.VolumeMounts {
+		for _, volume := range spec.Volumes {
+			if volume.Name == volumeMount.Name && volume.Projected != nil {
+				projected[volumeMount.MountPath] = volume.Projected.Sources[0]
+			}
+		}
+	}
+	if source := projected["/vdp/config"]; source.Secret == nil || source.Secret.Name != "job" || source.Secret.Items[0].Path != "job.json" {
+		t.Errorf("config projection = %+v, want the job.json Secret item", source)
+	}
+	if source := projected["/vdp/catalog"]; source.ConfigMap == nil || source.ConfigMap.Items[0].Path != "job.json" {
+		t.Errorf("catalog projection = %+v, want the job.json ConfigMap item", source)
+	}
+
+	// Resources, security context and the other container fields
+	if got := container.Resources.Limits[corev1.ResourceMemory]; got.String() != "1Gi" {
+		t.Errorf("memory limit = %s, want 1Gi", got.String())
+	}
+	if got := container.Resources.Requests[corev1.ResourceCPU]; got.String() != "500m" {
+		t.Errorf("CPU request = %s, want 500m", got.String())
+	}
+	securityContext := container.SecurityContext
+	if securityContext == nil || !*securityContext.ReadOnlyRootFilesystem || *securityContext.AllowPrivilegeEscalation ||
+		*securityContext.RunAsUser != 1000 || *securityContext.RunAsGroup != 1000 || securityContext.Capabilities.Drop[0] != "ALL" {
+		t.Errorf("security context = %+v, want the restricted profile", securityContext)
+	}
+	if !container.Stdin || !container.StdinOnce || container.ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Errorf("container = %+v, want stdin and the IfNotPresent pull policy", container)
+	}
+	if len(container.Env) != 1 || container.Env[0].Name != "JAVA_OPTS" || container.Env[0].Value != "-Xmx768m" {
+		t.Errorf("env = %v, want JAVA_OPTS", container.Env)
+	}
+	if spec.ImagePullSecrets[0].Name != "registry" || spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("Pod spec = %+v, want the image pull secret and no restart", spec)
+	}
+
+	assertKubernetesCleanedUp(t, clientset, "job")
+}
+
+func TestKubernetesRuntimeRunExitError(t *testing.T) {
+	r, clientset := newTestKubernetesRuntime(func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error {
+		if _, err := io.Copy(io.Discard, job.Stdin); err != nil {
+			return err
+		}
+		setPodStatus(t, r.clientset, pod.Name, terminatedStatus(corev1.PodFailed, 1, "Could not connect\n"))
+		return nil
+	})
+	setPodStatus(t, clientset, "job", corev1.PodStatus{Phase: corev1.PodRunning})
+
+	err := r.Run(context.Background(), testKubernetesJob(strings.NewReader("records\n"), io.Discard))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Run() error = %v, want an ExitError", err)
+	}
+	if exitErr.ExitCode != 1 || exitErr.StderrTail != "Could not connect" || exitErr.Container != "job" {
+		t.Errorf("ExitError = %+v, want exit code 1 and the termination message", exitErr)
+	}
+	assertKubernetesCleanedUp(t, clientset, "job")
+}
+
+func TestKubernetesRuntimeRunCompletedBeforeAttach(t *testing.T) {
+	r, clientset := newTestKubernetesRuntime(func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error {
+		t.Error("a completed Pod was attached")
+		return nil
+	})
+	setPodStatus(t, clientset, "job", terminatedStatus(corev1.PodSucceeded, 0, ""))
+
+	if err := r.Run(context.Background(), testKubernetesJob(strings.NewReader("records\n"), io.Discard)); err == nil {
+		t.Fatal("Run() succeeded without streaming the records")
+	}
+	assertKubernetesCleanedUp(t, clientset, "job")
+}
+
+func TestKubernetesRuntimeRunInputError(t *testing.T) {
+	inputErr := errors.New("invalid DataPayload")
+	r, clientset := newTestKubernetesRuntime(func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error {
+		_, err := io.Copy(io.Discard, job.Stdin)
+		// The Pod is deleted before the input error is returned
+		if _, getErr := r.clientset.CoreV1().Pods(testNamespace).Get(context.Background(), pod.Name, metav1.GetOptions{}); !apierrors.IsNotFound(getErr) {
+			t.Errorf("the Pod was not deleted when the input failed")
+		}
+		return err
+	})
+	setPodStatus(t, clientset, "job", corev1.PodStatus{Phase: corev1.PodRunning})
+
+	stdin := io.MultiReader(strings.NewReader("records\n"), &errorReader{err: inputErr})
+	if err := r.Run(context.Background(), testKubernetesJob(stdin, io.Discard)); !errors.Is(err, inputErr) {
+		t.Fatalf("Run() error = %v, want the input error", err)
+	}
+	assertKubernetesCleanedUp(t, clientset, "job")
+}
+
+func TestKubernetesRuntimeRunPullError(t *testing.T) {
+	r, clientset := newTestKubernetesRuntime(func(r *KubernetesRuntime, pod *corev1.Pod, job *ContainerJob) error {
+		t.Error("a Pod unable to pull its image was attached")
+		return nil
+	})
+	setPodStatus(t, clientset, "job", corev1.PodStatus{
+		Phase: corev1.PodPending,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  kubernetesContainerName,
+			Image: "airbyte/destination-postgres:0.3.27",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+		}},
+	})
+
+	err := r.Run(context.Background(), testKubernetesJob(strings.NewReader("records\n"), io.Discard))
+	var containerErr *ContainerError
+	if !errors.As(err, &containerErr) || containerErr.Op != ContainerOpPull {
+		t.Fatalf("Run() error = %v, want a pull ContainerError", err)
+	}
+	assertKubernetesCleanedUp(t, clientset, "job")
+}
+
+// errorReader fails every read
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// assertKubernetesCleanedUp checks that the Pod, the Secret and the
+// ConfigMap of the job are deleted
+func assertKubernetesCleanedUp(t *testing.T, clientset *fake.Clientset, name string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := clientset.CoreV1().Pods(testNamespace).Get(ctx, name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Pod %s not deleted: %v", name, err)
+	}
+	if _, err := clientset.CoreV1().Secrets(testNamespace).Get(ctx, name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Secret %s not deleted: %v", name, err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps(testNamespace).Get(ctx, name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("ConfigMap %s not deleted: %v", name, err)
+	}
+}
+
+func TestKubernetesRuntimeRunNetworkPolicy(t *testing.T) {
+	job := func() *ContainerJob {
+		job := testKubernetesJob(nil, io.Discard)
+		job.Security.NetworkMode = NetworkModeNone
+		return job
+	}
+
+	// Without NetworkPolicy, the Pod runs with a warning
+	core, logs := observer.New(zap.WarnLevel)
+	clientset := fake.NewSimpleClientset()
+	r := NewKubernetesRuntime(zap.New(core), nil, clientset, KubernetesRuntimeOptions{Namespace: testNamespace, PollInterval: 5 * time.Millisecond})
+	setPodStatus(t, clientset, "job", terminatedStatus(corev1.PodSucceeded, 0, ""))
+	if err := r.Run(context.Background(), job()); err != nil {
+		t.Fatal(err)
+	}
+	if logs.FilterMessageSnippet("no NetworkPolicy").Len() != 1 {
+		t.Errorf("logs = %v, want a NetworkPolicy warning", logs.All())
+	}
+
+	// A missing NetworkPolicy fails the job before the Pod is created
+	clientset = fake.NewSimpleClientset()
+	r = NewKubernetesRuntime(zap.NewNop(), nil, clientset, KubernetesRuntimeOptions{Namespace: testNamespace, PollInterval: 5 * time.Millisecond, NetworkPolicy: "connector-destination-no-network"})
+	if err := r.Run(context.Background(), job()); err == nil || !strings.Contains(err.Error(), "connector-destination-no-network") {
+		t.Fatalf("Run() error = %v, want the missing NetworkPolicy error", err)
+	}
+	if pods, err := clientset.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{}); err != nil || len(pods.Items) != 0 {
+		t.Errorf("pods = %v, %v, want none", pods, err)
+	}
+
+	// The Pod is labelled for the installed NetworkPolicy to isolate it
+	if _, err := clientset.NetworkingV1().NetworkPolicies(testNamespace).Create(context.Background(), &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "connector-destination-no-network", Namespace: testNamespace},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	labelled := make(chan bool, 1)
+	go func() {
+		for {
+			pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), "job", metav1.GetOptions{})
+			if err == nil {
+				labelled <- pod.Labels[kubernetesNetworkLabel] == NetworkModeNone
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	setPodStatus(t, clientset, "job", terminatedStatus(corev1.PodSucceeded, 0, ""))
+	if err := r.Run(context.Background(), job()); err != nil {
+		t.Fatal(err)
+	}
+	if !<-labelled {
+		t.Errorf("the Pod was not labelled %s=%s", kubernetesNetworkLabel, NetworkModeNone)
+	}
+}
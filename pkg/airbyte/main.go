@@ -1,32 +1,24 @@
 package airbyte
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	_ "embed"
 
-	"github.com/allegro/bigcache"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/gofrs/uuid"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/encoding/protojson"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	dockerclient "github.com/docker/docker/client"
 
+	"github.com/instill-ai/connector-destination/pkg/configschema"
 	"github.com/instill-ai/connector/pkg/base"
 	"github.com/instill-ai/connector/pkg/configLoader"
 
@@ -45,9 +37,31 @@ var connector base.IConnector
 
 type Connector struct {
 	base.BaseConnector
-	dockerClient *dockerclient.Client
-	cache        *bigcache.BigCache
-	options      ConnectorOptions
+	runtime ContainerRuntime
+	options ConnectorOptions
+
+	// idempotency records the completed writes
+	idempotency IdempotencyStore
+
+	secretProviders map[string]SecretProvider
+
+	// specs caches the ConnectorSpecifications per image digest
+	specs  map[string]*ConnectorSpecification
+	specMu sync.Mutex
+
+	// refreshed holds the definitions updated by RefreshSpec. They are
+	// replaced rather than updated, so that their readers never see them
+	// change.
+	refreshed   map[uuid.UUID]*connectorPB.ConnectorDefinition
+	refreshedMu sync.RWMutex
+
+	// loadedImages holds the IDs of the digest-pinned images loaded by
+	// LoadImages, which have no repository digest
+	loadedImages   map[string]string
+	loadedImagesMu sync.RWMutex
+
+	// pulls collapses the concurrent pulls of an image
+	pulls singleflight.Group
 }
 
 type ConnectorOptions struct {
@@ -57,6 +71,61 @@ type ConnectorOptions struct {
 	MountTargetAirbyte    string
 	VDPProtocolPath       string
 	ExcludeLocalConnector bool
+	// ContainerRuntime runs the destination images, defaults to the local Docker daemon
+	ContainerRuntime ContainerRuntime
+	// ImageStore stores the images of the connections with the offload image policy
+	ImageStore BlobStore
+	// IdempotencyStore records the completed writes, defaults to an in-memory
+	// store keeping them for an hour
+	IdempotencyStore IdempotencyStore
+	// SecretProviders resolve the secret references of the credential fields
+	// by scheme, e.g., vault
+	SecretProviders map[string]SecretProvider
+	// SecretEnvAllowlist enables the env scheme for these environment
+	// variables, a name ending with * being a prefix, e.g., VDP_SECRET_*
+	SecretEnvAllowlist []string
+	// SecretFileDir enables the file scheme for the files of this directory,
+	// e.g., /run/secrets
+	SecretFileDir string
+	// RetryPolicy retries the image pulls, the container creations and
+	// starts, and the writes failing with a transient error. The zero fields
+	// default to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// Timeouts bounds the duration of the containers, the zero fields
+	// defaulting to DefaultJobTimeouts. The containers are killed and
+	// removed once their timeout passes.
+	Timeouts JobTimeouts
+	// DefinitionTimeouts overrides Timeouts per definition ID
+	DefinitionTimeouts map[string]JobTimeouts
+	// ResourceCaps bounds the resources of the containers, which otherwise
+	// follow the resourceRequirements of the definitions
+	ResourceCaps ResourceCaps
+	// ImageArchiveDir holds the `docker save` archives loaded by LoadImages
+	ImageArchiveDir string
+	// RegistryAuth provides the credentials of the image registries
+	RegistryAuth RegistryAuth
+	// RegistryMirrors rewrites the dockerRepository prefixes of the
+	// definitions, e.g., airbyte/ to registry.internal/airbyte/
+	RegistryMirrors map[string]string
+	// PullPolicy is one of PullPolicyAlways, PullPolicyIfNotPresent (default)
+	// and PullPolicyNever
+	PullPolicy string
+	// ImageDigests pins the images per definition ID, e.g., sha256:..., the
+	// dockerImageDigest vendor attribute pinning them otherwise
+	ImageDigests map[string]string
+	// SecurityProfile defines the privileges of the containers, e.g.,
+	// RestrictedSecurityProfile. With the Docker runtime, a profile running
+	// the containers as another user than this process requires CopyJobFiles.
+	SecurityProfile SecurityProfile
+	// DefinitionSecurityProfiles overrides SecurityProfile per definition ID
+	DefinitionSecurityProfiles map[string]SecurityProfile
+	// CopyJobFiles copies the config and catalog into the containers instead
+	// of sharing them through the VDP volume, e.g., with a remote Docker
+	// daemon or docker-in-docker where the containers do not see the paths of
+	// this process. MountSourceVDP is not mounted then. The shared config is
+	// owned by the container user, hence the images running as a user name
+	// other than root require CopyJobFiles.
+	CopyJobFiles bool
 }
 
 type Connection struct {
@@ -64,464 +133,549 @@ type Connection struct {
 	connector *Connector
 	defUid    uuid.UUID
 	config    *structpb.Struct
+	options   connectionOptions
+	// secrets holds the credential field values masked in logs and errors
+	secrets *secretSet
 }
 
 func Init(logger *zap.Logger, options ConnectorOptions) base.IConnector {
 	once.Do(func() {
+		connector = newConnector(logger, options)
+	})
+	return connector
+}
 
-		loader := configLoader.InitJSONSchema(logger)
-		connDefs, err := loader.Load(vendorName, connectorPB.ConnectorType_CONNECTOR_TYPE_DESTINATION, destinationJson)
-		if err != nil {
-			panic(err)
-		}
+// newConnector returns a Connector with the seed definitions
+func newConnector(logger *zap.Logger, options ConnectorOptions) *Connector {
+
+	loader := configLoader.InitJSONSchema(logger)
+	connDefs, err := loader.Load(vendorName, connectorPB.ConnectorType_CONNECTOR_TYPE_DESTINATION, destinationJson)
+	if err != nil {
+		panic(err)
+	}
 
+	runtime := options.ContainerRuntime
+	if runtime == nil {
 		dockerClient, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
 		if err != nil {
 			logger.Error(err.Error())
 		}
 		// defer dockerClient.Close()
-		cache, err := bigcache.NewBigCache(bigcache.DefaultConfig(60 * time.Minute))
-		if err != nil {
-			logger.Error(err.Error())
+		runtime = NewDockerRuntime(logger, dockerClient)
+	}
+	if _, ok := runtime.(*DockerRuntime); ok {
+		if err := checkSharedFileUsers(options); err != nil {
+			panic(err)
 		}
+	}
 
-		connector = &Connector{
-			BaseConnector: base.BaseConnector{Logger: logger},
-			dockerClient:  dockerClient,
-			cache:         cache,
-			options:       options,
-		}
-		for idx := range connDefs {
-			if options.ExcludeLocalConnector &&
-				(connDefs[idx].Id == "airbyte-destination-local-json" || connDefs[idx].Id == "airbyte-destination-csv" ||
-					connDefs[idx].Id == "airbyte-destination-sqlite" || connDefs[idx].Id == "airbyte-destination-duckdb") {
-				connDefs[idx].Tombstone = true
-			}
-			err := connector.AddConnectorDefinition(uuid.FromStringOrNil(connDefs[idx].GetUid()), connDefs[idx].GetId(), connDefs[idx])
-			if err != nil {
-				logger.Warn(err.Error())
-			}
+	idempotency := options.IdempotencyStore
+	if idempotency == nil {
+		idempotency, err = NewMemoryIdempotencyStore(60 * time.Minute)
+		if err != nil {
+			logger.Error(err.Error())
 		}
-		InitAirbyteCatalog(logger, options.VDPProtocolPath)
-
-	})
-	return connector
-}
+	}
 
-func (c *Connector) PreDownloadImage(logger *zap.Logger, uids []uuid.UUID) error {
-	for _, uid := range uids {
-		connDef, err := c.GetConnectorDefinitionByUid(uid)
-		if err != nil {
-			logger.Warn(err.Error())
+	connector := &Connector{
+		BaseConnector:   base.BaseConnector{Logger: logger},
+		runtime:         runtime,
+		idempotency:     idempotency,
+		options:         options,
+		specs:           map[string]*ConnectorSpecification{},
+		refreshed:       map[uuid.UUID]*connectorPB.ConnectorDefinition{},
+		loadedImages:    map[string]string{},
+		secretProviders: defaultSecretProviders(options),
+	}
+	for idx := range connDefs {
+		if options.ExcludeLocalConnector && contains(localDefinitionIDs, connDefs[idx].Id) {
+			connDefs[idx].Tombstone = true
 		}
-		err = connector.AddConnectorDefinition(uuid.FromStringOrNil(connDef.GetUid()), connDef.GetId(), connDef)
+		err := connector.AddConnectorDefinition(uuid.FromStringOrNil(connDefs[idx].GetUid()), connDefs[idx].GetId(), connDefs[idx])
 		if err != nil {
 			logger.Warn(err.Error())
 		}
+	}
+	InitAirbyteCatalog(logger, options.VDPProtocolPath)
 
-		imageName := fmt.Sprintf("%s:%s",
-			connDef.VendorAttributes.GetFields()["dockerRepository"].GetStringValue(),
-			connDef.VendorAttributes.GetFields()["dockerImageTag"].GetStringValue())
-		logger.Info(fmt.Sprintf("download %s", imageName))
-		out, err := c.dockerClient.ImagePull(context.Background(), imageName, types.ImagePullOptions{})
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-		if _, err := io.Copy(os.Stdout, out); err != nil {
-			return err
-		}
+	// Remove the files left by a previous process, e.g., after a crash
+	if !options.CopyJobFiles {
+		removeStaleFiles(logger, fmt.Sprintf("%s/connector-data/config", options.MountTargetVDP))
+		removeStaleFiles(logger, fmt.Sprintf("%s/connector-data/catalog", options.MountTargetVDP))
 	}
-	return nil
+	return connector
 }
 
 func (c *Connector) CreateConnection(defUid uuid.UUID, config *structpb.Struct, logger *zap.Logger) (base.IConnection, error) {
 
+	def, err := c.GetConnectorDefinitionByUid(defUid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject unsupported sync mode combinations before any container is started
+	destinationConfig, options, err := parseConnectionOptions(def, config)
+	if err != nil {
+		return nil, err
+	}
+	if options.ImagePolicy == imagePolicyOffload && c.options.ImageStore == nil {
+		return nil, optionError(def, configKeyImagePolicy, "enum", "policy %s requires an image store", imagePolicyOffload)
+	}
+	secrets := newSecretSet(credentialValues(config, c.ListCredentialField(def.GetId()))...)
+	if err := configschema.Validate(def, destinationConfig); err != nil {
+		return nil, maskError(err, secrets)
+	}
+
 	return &Connection{
-		BaseConnection: base.BaseConnection{Logger: logger},
+		BaseConnection: base.BaseConnection{Logger: maskLogger(logger, secrets)},
 		connector:      c,
 		defUid:         defUid,
-		config:         config,
+		config:         destinationConfig,
+		options:        options,
+		secrets:        secrets,
 	}, nil
 }
 
-func (con *Connection) Execute(inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
-
-	// Create ConfiguredAirbyteCatalog
-	cfgAbCatalog := ConfiguredAirbyteCatalog{
-		Streams: []ConfiguredAirbyteStream{
-			{
-				Stream:              &TaskOutputAirbyteCatalog.Streams[0],
-				SyncMode:            "full_refresh", // TODO: config
-				DestinationSyncMode: "append",       // TODO: config
-			},
-		},
+// validateConfig validates the config against the current definition spec,
+// which may have been refreshed since the connection was created
+func (con *Connection) validateConfig() error {
+	def, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
+	if err != nil {
+		return err
 	}
+	return configschema.Validate(def, con.config)
+}
 
-	byteCfgAbCatalog, err := json.Marshal(&cfgAbCatalog)
+// configuration returns the connection config in JSON, the secret
+// references of the credential fields being resolved. The resolved secrets
+// are masked in the connection logs and errors.
+func (con *Connection) configuration(ctx context.Context) ([]byte, error) {
+	if con.config == nil {
+		return []byte{}, nil
+	}
+	def, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
 	if err != nil {
-		return nil, fmt.Errorf("marshal AirbyteMessage error: %w", err)
+		return nil, err
+	}
+	config, secrets, err := resolveSecretRefs(ctx, con.config, con.connector.ListCredentialField(def.GetId()), con.connector.secretProviders)
+	if err != nil {
+		return nil, err
 	}
+	con.secrets.add(secrets...)
+	return config.MarshalJSON()
+}
 
-	// Create AirbyteMessage RECORD type, i.e., AirbyteRecordMessage in JSON Line format
-	var byteAbMsgs []byte
+// Execute writes the DataPayloads to the destination, see ExecuteWithContext.
+// Each task output is written to the stream of its task, the streams being
+// the ones of the tasks of all the DataPayloads.
+func (con *Connection) Execute(inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
+	return con.ExecuteWithContext(context.Background(), inputs)
+}
+
+// ExecuteWithContext writes the DataPayloads to the destination, the
+// credential field values being masked in the returned error. The idempotency
+// key of the write is derived from the definition, the config and the
+// DataPayloads. The container is killed once ctx is done.
+func (con *Connection) ExecuteWithContext(ctx context.Context, inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
+	key, err := con.idempotencyKey(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return con.ExecuteWithIdempotencyKey(ctx, key, inputs)
+}
 
-	// TODO: should define new vdp_protocol for this
-	for idx, dataPayload := range inputs {
+// ExecuteWithIdempotencyKey writes the DataPayloads to the destination unless
+// a write with the same key has completed, in which case its outputs are
+// returned without starting a container. An empty key is derived from the
+// DataPayloads, as in ExecuteWithContext.
+func (con *Connection) ExecuteWithIdempotencyKey(ctx context.Context, key string, inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
 
-		dataPayload.Images = nil
+	if key == "" {
+		var err error
+		if key, err = con.idempotencyKey(inputs); err != nil {
+			return nil, err
+		}
+	}
 
-		b, err := protojson.MarshalOptions{
-			UseProtoNames: true,
-		}.Marshal(dataPayload)
+	storeKey := fmt.Sprintf("%s/%s", con.defUid, key)
+	if con.connector.idempotency != nil {
+		b, ok, err := con.connector.idempotency.Get(storeKey)
 		if err != nil {
-			return nil, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+			return nil, err
 		}
-		abMsg := AirbyteMessage{}
-		abMsg.Type = "RECORD"
-		abMsg.Record = &AirbyteRecordMessage{
-			Stream:    TaskOutputAirbyteCatalog.Streams[0].Name,
-			Data:      b,
-			EmittedAt: time.Now().UnixMilli(),
+		if ok {
+			con.Logger.Info(fmt.Sprintf("write %s already completed, replaying its outputs", key))
+			return unmarshalOutputs(b)
 		}
-		b, err = json.Marshal(&abMsg)
+	}
+
+	outputs, err := con.execute(ctx, inputs)
+	if err != nil {
+		return nil, maskError(err, con.secrets)
+	}
+
+	// Record the write only once completed, so that a failed write is retried
+	if con.connector.idempotency != nil {
+		b, err := marshalOutputs(outputs)
 		if err != nil {
-			return nil, fmt.Errorf("marshal AirbyteMessage error: %w", err)
+			return nil, err
+		}
+		if err := con.connector.idempotency.Set(storeKey, b); err != nil {
+			con.Logger.Error(err.Error())
 		}
-		b = []byte(string(b) + "\n")
-		byteAbMsgs = append(byteAbMsgs, b...)
 	}
+	return outputs, nil
+}
 
-	// Remove the last "\n"
-	byteAbMsgs = byteAbMsgs[:len(byteAbMsgs)-1]
+func (con *Connection) execute(ctx context.Context, inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
 
-	connDef, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
-	if err != nil {
+	if err := con.validateConfig(); err != nil {
 		return nil, err
 	}
-	imageName := fmt.Sprintf("%s:%s",
-		connDef.VendorAttributes.GetFields()["dockerRepository"].GetStringValue(),
-		connDef.VendorAttributes.GetFields()["dockerImageTag"].GetStringValue())
-	containerName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
-	configFileName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
-	catalogFileName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
 
-	// If there is already a container run dispatched in the previous attempt, return exitCodeOK directly
-	if _, err := con.connector.cache.Get(containerName); err == nil {
-		return nil, nil
+	// Reject the whole batch before any container is started
+	if con.options.ValidationPolicy == validationPolicyRejectBatch {
+		invalid, err := validateRecords(inputs, con.options.FlattenObjects)
+		if err != nil {
+			return nil, err
+		}
+		if len(invalid) > 0 {
+			return nil, invalid
+		}
+	}
+
+	// The whole write is retried on a transient failure, the DataPayloads
+	// being replayed from the start
+	var result *WriteResult
+	err := retry(ctx, con.Logger, con.connector.options.RetryPolicy, "write", func() error {
+		var err error
+		result, err = con.executeStream(ctx, NewSliceIterator(inputs), batchStreams(inputs, con.options.FlattenObjects))
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Write config into a container local file (always overwrite)
-	configFilePath := fmt.Sprintf("%s/connector-data/config/%s.json", con.connector.options.MountTargetVDP, configFileName)
-	if err := os.MkdirAll(filepath.Dir(configFilePath), os.ModePerm); err != nil {
-		return nil, fmt.Errorf(fmt.Sprintf("unable to create folders for filepath %s", configFilePath), "WriteContainerLocalFileError", err)
+	// The skipped or quarantined DataPayloads hold their validation errors
+	invalid := map[int64]*ValidationError{}
+	for _, validationErr := range result.Invalid {
+		invalid[validationErr.Index] = validationErr
 	}
 
-	configuration := func() []byte {
-		if con.config != nil {
-			b, err := con.config.MarshalJSON()
+	outputs := []*connectorPB.DataPayload{}
+	for idx := range inputs {
+		output := &connectorPB.DataPayload{
+			DataMappingIndex: inputs[idx].DataMappingIndex,
+		}
+		if validationErr, ok := invalid[int64(idx)]; ok {
+			metadata, err := validationErrorMetadata(validationErr)
 			if err != nil {
-				con.Logger.Error(err.Error())
+				return nil, err
 			}
-			return b
+			output.Metadata = metadata
 		}
-		return []byte{}
-	}()
-	if err := os.WriteFile(configFilePath, configuration, 0644); err != nil {
-		return nil, fmt.Errorf(fmt.Sprintf("unable to write connector config file %s", configFilePath), "WriteContainerLocalFileError", err)
+		outputs = append(outputs, output)
 	}
+	return outputs, nil
+}
 
-	// Write catalog into a container local file (always overwrite)
-	catalogFilePath := fmt.Sprintf("%s/connector-data/catalog/%s.json", con.connector.options.MountTargetVDP, catalogFileName)
-	if err := os.MkdirAll(filepath.Dir(catalogFilePath), os.ModePerm); err != nil {
-		return nil, fmt.Errorf(fmt.Sprintf("unable to create folders for filepath %s", catalogFilePath), "WriteContainerLocalFileError", err)
-	}
-	if err := os.WriteFile(catalogFilePath, byteCfgAbCatalog, 0644); err != nil {
-		return nil, fmt.Errorf(fmt.Sprintf("unable to write connector catalog file %s", catalogFilePath), "WriteContainerLocalFileError", err)
-	}
+// WriteResult holds the outcome of ExecuteStream
+type WriteResult struct {
+	// Written is the number of DataPayloads written to their task streams
+	Written int64
+	// Invalid holds the validation errors of the skipped or quarantined
+	// DataPayloads
+	Invalid ValidationErrors
+}
 
-	defer func() {
-		// Delete config local file
-		if _, err := os.Stat(configFilePath); err == nil {
-			if err := os.Remove(configFilePath); err != nil {
-				con.Logger.Error(fmt.Sprintln("Activity", "ImageName", imageName, "ContainerName", containerName, "Error", err))
-			}
-		}
+// ExecuteStream writes the DataPayloads of the iterator to the destination.
+// The payloads are marshalled and piped into the container one at a time,
+// hence the memory usage does not depend on the number of payloads. Each
+// task output is written to the stream of its task, the streams being the
+// ones of the first payload since the next ones are not known when the
+// container starts: all the payloads must have the tasks of the first one,
+// e.g., the outputs of a single pipeline. A payload with another task aborts
+// the write, as does an invalid payload with the reject_batch policy, the
+// container being killed before it commits the records already written.
+// Only the image pull is retried since the iterator cannot be replayed.
+func (con *Connection) ExecuteStream(it DataPayloadIterator) (*WriteResult, error) {
+	return con.ExecuteStreamWithContext(context.Background(), it)
+}
 
-		// Delete catalog local file
-		if _, err := os.Stat(catalogFilePath); err == nil {
-			if err := os.Remove(catalogFilePath); err != nil {
-				con.Logger.Error(fmt.Sprintln("Activity", "ImageName", imageName, "ContainerName", containerName, "Error", err))
-			}
-		}
-	}()
+// ExecuteStreamWithContext is ExecuteStream, the container being killed once
+// ctx is done
+func (con *Connection) ExecuteStreamWithContext(ctx context.Context, it DataPayloadIterator) (*WriteResult, error) {
+	result, err := con.executeStream(ctx, it, nil)
+	return result, maskError(err, con.secrets)
+}
 
-	out, err := con.connector.dockerClient.ImagePull(context.Background(), imageName, types.ImagePullOptions{})
-	if err != nil {
-		return nil, err
-	}
-	defer out.Close()
+// executeStream writes the DataPayloads of the iterator to the streams, the
+// ones of the first DataPayload if nil
+func (con *Connection) executeStream(ctx context.Context, it DataPayloadIterator, streams []string) (*WriteResult, error) {
 
-	if _, err := io.Copy(os.Stdout, out); err != nil {
+	if err := con.validateConfig(); err != nil {
 		return nil, err
 	}
 
-	resp, err := con.connector.dockerClient.ContainerCreate(context.Background(),
-		&container.Config{
-			Image:        imageName,
-			AttachStdin:  true,
-			AttachStdout: true,
-			OpenStdin:    true,
-			StdinOnce:    true,
-			Tty:          true,
-			Cmd: []string{
-				"write",
-				"--config",
-				configFilePath,
-				"--catalog",
-				catalogFilePath,
-			},
-		},
-		&container.HostConfig{
-			Mounts: []mount.Mount{
-				{
-					Type: func() mount.Type {
-						if string(con.connector.options.MountSourceVDP[0]) == "/" {
-							return mount.TypeBind
-						}
-						return mount.TypeVolume
-					}(),
-					Source: con.connector.options.MountSourceVDP,
-					Target: con.connector.options.MountTargetVDP,
-				},
-				{
-					Type: func() mount.Type {
-						if string(con.connector.options.MountSourceVDP[0]) == "/" {
-							return mount.TypeBind
-						}
-						return mount.TypeVolume
-					}(),
-					Source: con.connector.options.MountSourceAirbyte,
-					Target: con.connector.options.MountTargetAirbyte,
-				},
-			},
-		},
-		nil, nil, containerName)
+	result := &WriteResult{Invalid: ValidationErrors{}}
+
+	// Nothing to write, do not start a container
+	first, err := it.Next()
+	if err == io.EOF {
+		return result, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	it = &prependIterator{first: first, it: it}
 
-	hijackedResp, err := con.connector.dockerClient.ContainerAttach(context.Background(), resp.ID, types.ContainerAttachOptions{
-		Stdout: true,
-		Stdin:  true,
-		Stream: true,
-	})
-	if err != nil {
-		return nil, err
+	// Create ConfiguredAirbyteCatalog with the streams of the DataPayload
+	// tasks. Only these streams are configured so that the other task tables
+	// are left untouched, e.g., with the overwrite sync mode.
+	if streams == nil {
+		streams = payloadStreams(first, con.options.FlattenObjects)
+	}
+	if con.options.ValidationPolicy == validationPolicyQuarantine {
+		streams = append(streams, quarantineStreamName)
+	}
+	cfgAbCatalog := ConfiguredAirbyteCatalog{
+		Streams: []ConfiguredAirbyteStream{},
+	}
+	for _, streamName := range streams {
+		stream, err := getTaskStream(streamName)
+		if err != nil {
+			return nil, err
+		}
+		cfgAbCatalog.Streams = append(cfgAbCatalog.Streams, ConfiguredAirbyteStream{
+			Stream:              stream,
+			SyncMode:            con.options.SyncMode,
+			CursorField:         con.options.CursorField,
+			DestinationSyncMode: con.options.DestinationSyncMode,
+			PrimaryKey:          con.options.PrimaryKey,
+		})
 	}
 
-	// need to append "\n" and "ctrl+D" at the end of the input message
-	_, err = hijackedResp.Conn.Write(append(byteAbMsgs, 10, 4))
+	byteCfgAbCatalog, err := json.Marshal(&cfgAbCatalog)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("marshal AirbyteMessage error: %w", err)
 	}
 
-	if err := con.connector.dockerClient.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
+	connDef, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
+	if err != nil {
 		return nil, err
 	}
+	imageName := con.connector.imageName(connDef)
+	containerName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
+	configFileName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
+	catalogFileName := fmt.Sprintf("%s.%d.write", con.defUid, time.Now().UnixNano())
+
+	configFilePath := fmt.Sprintf("%s/connector-data/config/%s.json", con.connector.options.MountTargetVDP, configFileName)
+	catalogFilePath := fmt.Sprintf("%s/connector-data/catalog/%s.json", con.connector.options.MountTargetVDP, catalogFileName)
 
-	var bufStdOut bytes.Buffer
-	if _, err := bufStdOut.ReadFrom(hijackedResp.Reader); err != nil {
+	// Secret references are resolved only when the config file is written
+	config, err := con.configuration(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := con.connector.dockerClient.ContainerRemove(context.Background(), resp.ID,
-		types.ContainerRemoveOptions{
-			RemoveVolumes: true,
-			Force:         true,
-		}); err != nil {
+	if err := con.connector.pullImage(ctx, con.Logger, imageName); err != nil {
 		return nil, err
 	}
 
-	// Set cache flag (empty value is fine since we need only the entry record)
-	if err := con.connector.cache.Set(containerName, []byte{}); err != nil {
+	// Create AirbyteMessage RECORD type, i.e., AirbyteRecordMessage in JSON Line
+	// format, and stream them into the container standard input
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	var writeErr error
+	go func() {
+		defer close(done)
+		images := &imageHandler{policy: con.options.ImagePolicy, store: con.connector.options.ImageStore}
+		result.Written, result.Invalid, writeErr = writeRecords(ctx, pw, it, streams, con.options, images)
+		pw.CloseWithError(writeErr)
+	}()
+
+	parser := newOutputParser(con.Logger, zap.String("ImageName", imageName), zap.String("ContainerName", containerName))
+	err = con.connector.runJob(ctx, connDef, jobTypeSync, &ContainerJob{
+		Name:  containerName,
+		Image: imageName,
+		Cmd: []string{
+			"write",
+			"--config",
+			configFilePath,
+			"--catalog",
+			catalogFilePath,
+		},
+		Mounts: con.connector.jobMounts(connDef, true),
+		Files: []ContainerFile{
+			{
+				Path:    configFilePath,
+				Content: config,
+				Secret:  true,
+			},
+			{
+				Path:    catalogFilePath,
+				Content: byteCfgAbCatalog,
+			},
+		},
+		Stdin:  pr,
+		Stdout: parser,
+	})
+	parser.Close()
+	// Unblock the writer if the container stopped reading early
+	pr.Close()
+	<-done
+	// The input error explains why the container was killed, if any, unless
+	// the container stopped reading the records
+	if writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		return nil, writeErr
+	}
+	// The destination error explains why it exited or stopped reading the
+	// records, if any
+	var exitErr *ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		if err := parser.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
 		return nil, err
 	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
 
 	con.Logger.Info(fmt.Sprintln("Activity",
 		"ImageName", imageName,
 		"ContainerName", containerName,
-		"STDOUT", bufStdOut.String()))
+		"Records", result.Written,
+		"Invalid", len(result.Invalid),
+		"States", len(parser.States)))
 
-	// Delete the cache entry only after the write completed
-	if err := con.connector.cache.Delete(containerName); err != nil {
-		con.Logger.Error(err.Error())
-	}
-
-	outputs := []*connectorPB.DataPayload{}
-	for idx := range inputs {
-		outputs = append(outputs, &connectorPB.DataPayload{
-			DataMappingIndex: inputs[idx].DataMappingIndex,
-		})
-	}
-	return outputs, nil
+	return result, nil
 }
 
+// Test checks the connection to the destination, see TestWithContext
 func (con *Connection) Test() (connectorPB.Connector_State, error) {
+	return con.TestWithContext(context.Background())
+}
+
+// TestWithContext checks the connection to the destination, the credential
+// field values being masked in the returned error. The container is killed
+// once ctx is done.
+func (con *Connection) TestWithContext(ctx context.Context) (connectorPB.Connector_State, error) {
+	state, err := con.test(ctx)
+	return state, maskError(err, con.secrets)
+}
+
+func (con *Connection) test(ctx context.Context) (connectorPB.Connector_State, error) {
 
 	def, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
 	if err != nil {
 		return connectorPB.Connector_STATE_ERROR, err
 	}
-	imageName := fmt.Sprintf("%s:%s",
-		def.VendorAttributes.GetFields()["dockerRepository"].GetStringValue(),
-		def.VendorAttributes.GetFields()["dockerImageTag"].GetStringValue())
-	containerName := fmt.Sprintf("%s.%d.check", con.defUid, time.Now().UnixNano())
-	configFilePath := fmt.Sprintf("%s/connector-data/config/%s.json", con.connector.options.MountTargetVDP, containerName)
-
-	// Write config into a container local file
-	if err := os.MkdirAll(filepath.Dir(configFilePath), os.ModePerm); err != nil {
-		return connectorPB.Connector_STATE_ERROR, fmt.Errorf(fmt.Sprintf("unable to create folders for filepath %s", configFilePath), "WriteContainerLocalFileError", err)
-	}
-
-	configuration := func() []byte {
-		if con.config != nil {
-			b, err := con.config.MarshalJSON()
-			if err != nil {
-				con.Logger.Error(err.Error())
-			}
-			return b
-		}
-		return []byte{}
-	}()
-
-	if err := os.WriteFile(configFilePath, configuration, 0644); err != nil {
-		return connectorPB.Connector_STATE_ERROR, fmt.Errorf(fmt.Sprintf("unable to write connector config file %s", configFilePath), "WriteContainerLocalFileError", err)
+	if err := configschema.Validate(def, con.config); err != nil {
+		return connectorPB.Connector_STATE_ERROR, err
 	}
+	imageName := con.connector.imageName(def)
 
-	defer func() {
-		// Delete config local file
-		if _, err := os.Stat(configFilePath); err == nil {
-			if err := os.Remove(configFilePath); err != nil {
-				con.Logger.Error(fmt.Sprintf("ImageName: %s, ContainerName: %s, Error: %v", imageName, containerName, err))
-			}
-		}
-	}()
-
-	out, err := con.connector.dockerClient.ImagePull(context.Background(), imageName, types.ImagePullOptions{})
+	// Secret references are resolved only when the config file is written
+	config, err := con.configuration(ctx)
 	if err != nil {
 		return connectorPB.Connector_STATE_ERROR, err
 	}
-	defer out.Close()
 
-	if _, err := io.Copy(os.Stdout, out); err != nil {
+	if err := con.connector.pullImage(ctx, con.Logger, imageName); err != nil {
 		return connectorPB.Connector_STATE_ERROR, err
 	}
 
-	resp, err := con.connector.dockerClient.ContainerCreate(context.Background(),
-		&container.Config{
-			Image: imageName,
-			Tty:   false,
-			Cmd: []string{
-				"check",
-				"--config",
-				configFilePath,
-			},
-		},
-		&container.HostConfig{
-			Mounts: []mount.Mount{
-				{
-					Type: func() mount.Type {
-						if string(con.connector.options.MountSourceVDP[0]) == "/" {
-							return mount.TypeBind
-						}
-						return mount.TypeVolume
-					}(),
-					Source: con.connector.options.MountSourceVDP,
-					Target: con.connector.options.MountTargetVDP,
-				},
-			},
-		},
-		nil, nil, containerName)
+	// Each attempt runs a new container
+	var state connectorPB.Connector_State
+	err = retry(ctx, con.Logger, con.connector.options.RetryPolicy, "check", func() error {
+		var err error
+		state, err = con.check(ctx, def, imageName, config)
+		return err
+	})
 	if err != nil {
 		return connectorPB.Connector_STATE_ERROR, err
 	}
+	return state, nil
+}
 
-	if err := con.connector.dockerClient.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
-		return connectorPB.Connector_STATE_ERROR, err
-	}
+// check runs the check command of the image
+func (con *Connection) check(ctx context.Context, def *connectorPB.ConnectorDefinition, imageName string, config []byte) (connectorPB.Connector_State, error) {
 
-	statusCh, errCh := con.connector.dockerClient.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return connectorPB.Connector_STATE_ERROR, err
-		}
-	case <-statusCh:
-	}
+	containerName := fmt.Sprintf("%s.%d.check", con.defUid, time.Now().UnixNano())
+	configFilePath := fmt.Sprintf("%s/connector-data/config/%s.json", con.connector.options.MountTargetVDP, containerName)
 
-	if out, err = con.connector.dockerClient.ContainerLogs(context.Background(),
-		resp.ID,
-		types.ContainerLogsOptions{
-			ShowStdout: true,
+	// The outputs are parsed as they arrive and logged through the masking
+	// logger, the stderr lines being logged at debug level
+	parser := newOutputParser(con.Logger, zap.String("ImageName", imageName), zap.String("ContainerName", containerName))
+	stderrParser := newOutputParser(con.Logger, zap.String("ImageName", imageName), zap.String("ContainerName", containerName))
+	err := con.connector.runJob(ctx, def, jobTypeCheck, &ContainerJob{
+		Name:  containerName,
+		Image: imageName,
+		Cmd: []string{
+			"check",
+			"--config",
+			configFilePath,
 		},
-	); err != nil {
-		return connectorPB.Connector_STATE_ERROR, err
-	}
-
-	if err := con.connector.dockerClient.ContainerRemove(context.Background(), containerName,
-		types.ContainerRemoveOptions{
-			RemoveVolumes: true,
-			Force:         true,
-		}); err != nil {
-		return connectorPB.Connector_STATE_ERROR, err
-	}
+		Mounts: con.connector.jobMounts(def, false),
+		Files: []ContainerFile{
+			{
+				Path:    configFilePath,
+				Content: config,
+				Secret:  true,
+			},
+		},
+		Stdout: parser,
+		Stderr: stderrParser,
+	})
+	parser.Close()
+	stderrParser.Close()
 
-	var bufStdOut, bufStdErr bytes.Buffer
-	if _, err := stdcopy.StdCopy(&bufStdOut, &bufStdErr, out); err != nil {
+	// A destination may exit with a non-zero code after reporting a failed
+	// connection status
+	var exitErr *ExitError
+	if err != nil && !(errors.As(err, &exitErr) && parser.ConnectionStatus != nil) {
 		return connectorPB.Connector_STATE_ERROR, err
 	}
 
-	var teeStdOut io.Reader = strings.NewReader(bufStdOut.String())
-	var teeStdErr io.Reader = strings.NewReader(bufStdErr.String())
-	teeStdOut = io.TeeReader(teeStdOut, &bufStdOut)
-	teeStdErr = io.TeeReader(teeStdErr, &bufStdErr)
-
-	var byteStdOut, byteStdErr []byte
-	if byteStdOut, err = io.ReadAll(teeStdOut); err != nil {
-		return connectorPB.Connector_STATE_ERROR, err
-	}
-	if byteStdErr, err = io.ReadAll(teeStdErr); err != nil {
-		return connectorPB.Connector_STATE_ERROR, err
+	if parser.ConnectionStatus == nil {
+		return connectorPB.Connector_STATE_ERROR, nil
 	}
-
-	con.Logger.Info(fmt.Sprintf("ImageName, %s, ContainerName, %s, STDOUT, %v, STDERR, %v", imageName, containerName, byteStdOut, byteStdErr))
-
-	scanner := bufio.NewScanner(&bufStdOut)
-	for scanner.Scan() {
-
-		if err := scanner.Err(); err != nil {
-			return connectorPB.Connector_STATE_ERROR, err
-		}
-
-		var jsonMsg map[string]interface{}
-		if err := json.Unmarshal(scanner.Bytes(), &jsonMsg); err == nil {
-			switch jsonMsg["type"] {
-			case "CONNECTION_STATUS":
-				switch jsonMsg["connectionStatus"].(map[string]interface{})["status"] {
-				case "SUCCEEDED":
-					return connectorPB.Connector_STATE_CONNECTED, nil
-				case "FAILED":
-					return connectorPB.Connector_STATE_ERROR, nil
-				default:
-					return connectorPB.Connector_STATE_ERROR, fmt.Errorf("UNKNOWN STATUS")
-				}
-			}
-		}
+	switch parser.ConnectionStatus.Status {
+	case "SUCCEEDED":
+		return connectorPB.Connector_STATE_CONNECTED, nil
+	case "FAILED":
+		return connectorPB.Connector_STATE_ERROR, nil
+	default:
+		return connectorPB.Connector_STATE_ERROR, fmt.Errorf("UNKNOWN STATUS")
 	}
-	return connectorPB.Connector_STATE_ERROR, nil
 }
 
 func (con *Connection) GetTask() (connectorPB.Task, error) {
 	return connectorPB.Task_TASK_UNSPECIFIED, nil
 }
+
+// jobMounts returns the mounts of a job of the definition. The destination
+// only reads the config and catalog from the VDP volume, which is not mounted
+// if the files are copied, and only the local destinations write to the
+// Airbyte volume.
+func (c *Connector) jobMounts(def *connectorPB.ConnectorDefinition, withAirbyte bool) []ContainerMount {
+	mounts := []ContainerMount{}
+	if !c.options.CopyJobFiles {
+		mounts = append(mounts, ContainerMount{
+			Source:   c.options.MountSourceVDP,
+			Target:   c.options.MountTargetVDP,
+			ReadOnly: true,
+		})
+	}
+	if withAirbyte && c.options.MountSourceAirbyte != "" {
+		mounts = append(mounts, ContainerMount{
+			Source:   c.options.MountSourceAirbyte,
+			Target:   c.options.MountTargetAirbyte,
+			ReadOnly: !contains(localDefinitionIDs, def.GetId()),
+		})
+	}
+	return mounts
+}
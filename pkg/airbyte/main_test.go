@@ -0,0 +1,294 @@
+package airbyte
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+const testPassword = "pg-password"
+
+// newTestConnection returns a postgres connection running its jobs with the
+// runtime
+func newTestConnection(t *testing.T, runtime ContainerRuntime) *Connection {
+	t.Helper()
+	return newTestConnectionWithOptions(t, runtime, nil)
+}
+
+// newTestConnectionWithOptions returns a postgres connection with the
+// connection options, e.g., validation_policy
+func newTestConnectionWithOptions(t *testing.T, runtime ContainerRuntime, options map[string]interface{}) *Connection {
+	t.Helper()
+	c := newTestConnector(t, runtime, ConnectorOptions{})
+	fields := map[string]interface{}{
+		"host":     "localhost",
+		"port":     5432,
+		"username": "vdp",
+		"password": testPassword,
+		"database": "vdp",
+		"schema":   "public",
+	}
+	for key, value := range options {
+		fields[key] = value
+	}
+	config, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	con, err := c.CreateConnection(postgresDefUID, config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return con.(*Connection)
+}
+
+// testDataPayload returns a DataPayload with the outputs of the tasks
+func testDataPayload(t *testing.T, idx string, outputs map[string]interface{}) *connectorPB.DataPayload {
+	t.Helper()
+	structuredData, err := structpb.NewStruct(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &connectorPB.DataPayload{DataMappingIndex: idx, StructuredData: structuredData}
+}
+
+var (
+	classificationOutput = map[string]interface{}{
+		"classification": map[string]interface{}{"category": "cat", "score": 0.9},
+	}
+	detectionOutput = map[string]interface{}{
+		"detection": map[string]interface{}{"objects": []interface{}{}},
+	}
+)
+
+// records returns the AirbyteRecordMessages of the container standard input
+func records(t *testing.T, stdin []byte) []*AirbyteRecordMessage {
+	t.Helper()
+	records := []*AirbyteRecordMessage{}
+	scanner := bufio.NewScanner(bytes.NewReader(stdin))
+	for scanner.Scan() {
+		var message AirbyteMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != AirbyteMessageTypeRecord {
+			t.Fatalf("message type = %s, want RECORD", message.Type)
+		}
+		records = append(records, message.Record)
+	}
+	return records
+}
+
+func TestExecute(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error {
+		return writeMessages(job.Stdout, AirbyteMessage{
+			Type:  AirbyteMessageTypeState,
+			State: &AirbyteStateMessage{Data: json.RawMessage(`{}`)},
+		})
+	}}
+	con := newTestConnection(t, runtime)
+
+	inputs := []*connectorPB.DataPayload{
+		testDataPayload(t, "01", classificationOutput),
+		testDataPayload(t, "02", detectionOutput),
+	}
+	outputs, err := con.Execute(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || outputs[0].DataMappingIndex != "01" || outputs[1].DataMappingIndex != "02" {
+		t.Errorf("outputs = %v, want one per input", outputs)
+	}
+
+	runs := runtime.Runs()
+	if len(runs) != 1 {
+		t.Fatalf("runs = %d, want 1", len(runs))
+	}
+	job := runs[0].Job
+	if job.Cmd[0] != "write" {
+		t.Errorf("command = %v, want write", job.Cmd)
+	}
+
+	// The records of all the tasks are written to their streams
+	got := records(t, runs[0].Stdin)
+	if len(got) != 2 || got[0].Stream != "classification" || got[1].Stream != "detection" {
+		t.Fatalf("records = %v, want a classification and a detection record", got)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(got[0].Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data["category"] != "cat" || data["data_mapping_index"] != "01" {
+		t.Errorf("classification record = %v", data)
+	}
+
+	// The catalog configures the streams of all the tasks and the config is
+	// the destination one
+	var catalog ConfiguredAirbyteCatalog
+	if err := json.Unmarshal(job.Files[1].Content, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	streams := []string{}
+	for _, stream := range catalog.Streams {
+		streams = append(streams, stream.Stream.Name)
+	}
+	if strings.Join(streams, ",") != "classification,detection" {
+		t.Errorf("catalog streams = %v, want classification and detection", streams)
+	}
+	if !job.Files[0].Secret || !strings.Contains(string(job.Files[0].Content), testPassword) {
+		t.Errorf("config file = %s, want the secret destination config", job.Files[0].Content)
+	}
+}
+
+func TestExecuteTextsAndAudios(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error { return nil }}
+	con := newTestConnection(t, runtime)
+
+	input := testDataPayload(t, "01", classificationOutput)
+	input.Texts = []string{"a cat"}
+	input.Audios = [][]byte{[]byte("audio")}
+	if _, err := con.Execute([]*connectorPB.DataPayload{input}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := records(t, runtime.Runs()[0].Stdin)
+	if len(got) != 1 {
+		t.Fatalf("records = %v, want 1", got)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(got[0].Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	texts, _ := data["texts"].([]interface{})
+	if len(texts) != 1 || texts[0] != "a cat" {
+		t.Errorf("texts = %v, want the DataPayload texts", data["texts"])
+	}
+	audios, _ := data["audios"].([]interface{})
+	if len(audios) != 1 || audios[0] != base64.StdEncoding.EncodeToString([]byte("audio")) {
+		t.Errorf("audios = %v, want the DataPayload audios in base64", data["audios"])
+	}
+}
+
+func TestExecuteTraceError(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error {
+		if err := writeMessages(job.Stdout, AirbyteMessage{
+			Type: AirbyteMessageTypeTrace,
+			Trace: &AirbyteTraceMessage{
+				Type: AirbyteTraceTypeError,
+				Error: &AirbyteErrorTraceMessage{
+					Message:     "password authentication failed for " + testPassword,
+					FailureType: FailureTypeConfigError,
+				},
+			},
+		}); err != nil {
+			return err
+		}
+		return &ExitError{Image: job.Image, Container: job.Name, ExitCode: 1}
+	}}
+	con := newTestConnection(t, runtime)
+
+	_, err := con.Execute([]*connectorPB.DataPayload{testDataPayload(t, "01", classificationOutput)})
+	var traceErr *TraceError
+	if !errors.As(err, &traceErr) || traceErr.FailureType != FailureTypeConfigError {
+		t.Fatalf("Execute() error = %v, want the config_error TraceError", err)
+	}
+	if strings.Contains(err.Error(), testPassword) {
+		t.Errorf("Execute() error = %v, want the password masked", err)
+	}
+	// A config error is not retried
+	if runs := len(runtime.Runs()); runs != 1 {
+		t.Errorf("runs = %d, want 1", runs)
+	}
+}
+
+func TestExecuteExitError(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error {
+		return &ExitError{Image: job.Image, Container: job.Name, ExitCode: 2, StderrTail: "out of memory"}
+	}}
+	con := newTestConnection(t, runtime)
+
+	_, err := con.Execute([]*connectorPB.DataPayload{testDataPayload(t, "01", classificationOutput)})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 2 || exitErr.StderrTail != "out of memory" {
+		t.Fatalf("Execute() error = %v, want the exit code 2 ExitError", err)
+	}
+}
+
+func TestExecuteStreamInputError(t *testing.T) {
+	con := newTestConnection(t, &inputFailureRuntime{fakeRuntime: &fakeRuntime{}})
+
+	// The streams are the ones of the first DataPayload
+	_, err := con.ExecuteStream(NewSliceIterator([]*connectorPB.DataPayload{
+		testDataPayload(t, "01", classificationOutput),
+		testDataPayload(t, "02", detectionOutput),
+	}))
+	var exitErr *ExitError
+	if err == nil || errors.As(err, &exitErr) || !strings.Contains(err.Error(), "not in the catalog streams") {
+		t.Fatalf("ExecuteStream() error = %v, want the input error", err)
+	}
+}
+
+// inputFailureRuntime kills the container when its input fails, as the
+// Docker and Kubernetes runtimes do
+type inputFailureRuntime struct {
+	*fakeRuntime
+}
+
+func (r *inputFailureRuntime) Run(ctx context.Context, job *ContainerJob) error {
+	if err := r.fakeRuntime.Run(ctx, job); err == nil {
+		return nil
+	}
+	return &ExitError{Image: job.Image, Container: job.Name, ExitCode: 137}
+}
+
+func TestTest(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    *AirbyteConnectionStatus
+		exitCode  int64
+		wantState connectorPB.Connector_State
+		wantErr   bool
+	}{
+		{name: "succeeded", status: &AirbyteConnectionStatus{Status: "SUCCEEDED"}, wantState: connectorPB.Connector_STATE_CONNECTED},
+		{name: "failed", status: &AirbyteConnectionStatus{Status: "FAILED", Message: "Could not connect"}, wantState: connectorPB.Connector_STATE_ERROR},
+		{name: "failed with exit code", status: &AirbyteConnectionStatus{Status: "FAILED"}, exitCode: 1, wantState: connectorPB.Connector_STATE_ERROR},
+		{name: "unknown status", status: &AirbyteConnectionStatus{Status: "UNKNOWN"}, wantState: connectorPB.Connector_STATE_ERROR, wantErr: true},
+		{name: "no status", wantState: connectorPB.Connector_STATE_ERROR},
+		{name: "exit code without status", exitCode: 1, wantState: connectorPB.Connector_STATE_ERROR, wantErr: true},
+	}
+	for _, tt := range tests {
+		runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error {
+			if job.Cmd[0] != "check" {
+				t.Errorf("%s: command = %v, want check", tt.name, job.Cmd)
+			}
+			if tt.status != nil {
+				if err := writeMessages(job.Stdout, AirbyteMessage{Type: AirbyteMessageTypeConnectionStatus, ConnectionStatus: tt.status}); err != nil {
+					return err
+				}
+			}
+			if tt.exitCode != 0 {
+				return &ExitError{Image: job.Image, Container: job.Name, ExitCode: tt.exitCode}
+			}
+			return nil
+		}}
+		con := newTestConnection(t, runtime)
+
+		state, err := con.Test()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Test() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if state != tt.wantState {
+			t.Errorf("%s: Test() = %v, want %v", tt.name, state, tt.wantState)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package airbyte
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/instill-ai/connector-destination/pkg/configschema"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Connection config keys handled by the connector itself. They are removed
+// from the config before it is passed to the destination container.
+const (
+	configKeyDestinationSyncMode = "destination_sync_mode"
+	configKeyCursorField         = "cursor_field"
+	configKeyPrimaryKey          = "primary_key"
+	configKeyFlattenObjects      = "flatten_objects"
+	configKeyValidationPolicy    = "validation_policy"
+	configKeyImagePolicy         = "image_policy"
+)
+
+// Airbyte sync modes
+const (
+	syncModeFullRefresh = "full_refresh"
+	syncModeIncremental = "incremental"
+
+	destinationSyncModeAppend      = "append"
+	destinationSyncModeOverwrite   = "overwrite"
+	destinationSyncModeAppendDedup = "append_dedup"
+)
+
+// connectionOptions holds the connection config values handled by the
+// connector rather than by the destination
+type connectionOptions struct {
+	SyncMode            string
+	DestinationSyncMode string
+	CursorField         []string
+	PrimaryKey          [][]string
+	// FlattenObjects writes the object-level task outputs one record per
+	// object, a primary key should then include the object_index
+	FlattenObjects bool
+	// ValidationPolicy decides what happens to the DataPayloads failing the
+	// validation against the VDP protocol. It defaults to reject_batch, hence
+	// an invalid DataPayload fails its whole batch where it used to be
+	// written: skip_record or quarantine still write the valid ones.
+	ValidationPolicy string
+	// ImagePolicy decides how the DataPayload images and the text_to_image
+	// output images are written
+	ImagePolicy string
+}
+
+// parseConnectionOptions splits the connection config into the destination
+// config and the connection options, and validates the options against the
+// connector definition. The invalid options are returned as a
+// *configschema.ConfigError naming the option.
+func parseConnectionOptions(def *connectorPB.ConnectorDefinition, config *structpb.Struct) (*structpb.Struct, connectionOptions, error) {
+
+	supportedModes := []string{}
+	for _, mode := range def.GetVendorAttributes().GetFields()["spec"].GetStructValue().GetFields()["supported_destination_sync_modes"].GetListValue().GetValues() {
+		supportedModes = append(supportedModes, mode.GetStringValue())
+	}
+
+	// Default to append. The other modes may overwrite the tables, hence they
+	// are never picked by default.
+	options := connectionOptions{
+		DestinationSyncMode: destinationSyncModeAppend,
+		ValidationPolicy:    validationPolicyRejectBatch,
+		ImagePolicy:         imagePolicyDrop,
+	}
+
+	var destinationConfig *structpb.Struct
+	if config != nil {
+		destinationConfig = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		for key, value := range config.GetFields() {
+			destinationConfig.Fields[key] = value
+		}
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyDestinationSyncMode]; ok {
+		delete(destinationConfig.Fields, configKeyDestinationSyncMode)
+		options.DestinationSyncMode = v.GetStringValue()
+	} else if len(supportedModes) > 0 && !contains(supportedModes, destinationSyncModeAppend) {
+		return nil, options, optionError(def, configKeyDestinationSyncMode, "required", "required, %s is not supported", destinationSyncModeAppend)
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyCursorField]; ok {
+		delete(destinationConfig.Fields, configKeyCursorField)
+		cursorField, err := parseFieldPath(v)
+		if err != nil {
+			return nil, options, optionError(def, configKeyCursorField, "type", "%v", err)
+		}
+		options.CursorField = cursorField
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyPrimaryKey]; ok {
+		delete(destinationConfig.Fields, configKeyPrimaryKey)
+		primaryKey, err := parsePrimaryKey(v)
+		if err != nil {
+			return nil, options, optionError(def, configKeyPrimaryKey, "type", "%v", err)
+		}
+		options.PrimaryKey = primaryKey
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyFlattenObjects]; ok {
+		delete(destinationConfig.Fields, configKeyFlattenObjects)
+		if _, ok := v.GetKind().(*structpb.Value_BoolValue); !ok {
+			return nil, options, optionError(def, configKeyFlattenObjects, "type", "must be a boolean")
+		}
+		options.FlattenObjects = v.GetBoolValue()
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyValidationPolicy]; ok {
+		delete(destinationConfig.Fields, configKeyValidationPolicy)
+		options.ValidationPolicy = v.GetStringValue()
+		switch options.ValidationPolicy {
+		case validationPolicyRejectBatch, validationPolicySkipRecord, validationPolicyQuarantine:
+		default:
+			return nil, options, optionError(def, configKeyValidationPolicy, "enum", "unknown policy %s", options.ValidationPolicy)
+		}
+	}
+
+	if v, ok := destinationConfig.GetFields()[configKeyImagePolicy]; ok {
+		delete(destinationConfig.Fields, configKeyImagePolicy)
+		options.ImagePolicy = v.GetStringValue()
+		switch options.ImagePolicy {
+		case imagePolicyDrop, imagePolicyInline, imagePolicyOffload:
+		default:
+			return nil, options, optionError(def, configKeyImagePolicy, "enum", "unknown policy %s", options.ImagePolicy)
+		}
+	}
+
+	switch options.DestinationSyncMode {
+	case destinationSyncModeOverwrite:
+		if len(options.CursorField) > 0 {
+			return nil, options, optionError(def, configKeyCursorField, "not", "not supported with %s %s", configKeyDestinationSyncMode, options.DestinationSyncMode)
+		}
+		options.SyncMode = syncModeFullRefresh
+	case destinationSyncModeAppend:
+		options.SyncMode = syncModeFullRefresh
+		if len(options.CursorField) > 0 {
+			options.SyncMode = syncModeIncremental
+		}
+	case destinationSyncModeAppendDedup:
+		if len(options.PrimaryKey) == 0 {
+			return nil, options, optionError(def, configKeyPrimaryKey, "required", "required with %s %s", configKeyDestinationSyncMode, options.DestinationSyncMode)
+		}
+		options.SyncMode = syncModeIncremental
+	default:
+		return nil, options, optionError(def, configKeyDestinationSyncMode, "enum", "unknown mode %s", options.DestinationSyncMode)
+	}
+
+	if len(options.PrimaryKey) > 0 && options.DestinationSyncMode != destinationSyncModeAppendDedup {
+		return nil, options, optionError(def, configKeyPrimaryKey, "not", "only supported with %s %s", configKeyDestinationSyncMode, destinationSyncModeAppendDedup)
+	}
+
+	if !contains(supportedModes, options.DestinationSyncMode) {
+		return nil, options, optionError(def, configKeyDestinationSyncMode, "enum", "mode %s is not supported", options.DestinationSyncMode)
+	}
+
+	return destinationConfig, options, nil
+}
+
+// optionError returns the *configschema.ConfigError of an invalid option
+func optionError(def *connectorPB.ConnectorDefinition, key string, keyword string, format string, args ...interface{}) error {
+	return &configschema.ConfigError{
+		DefinitionID: def.GetId(),
+		Errors:       []configschema.FieldError{{Path: key, Keyword: keyword, Message: fmt.Sprintf(format, args...)}},
+	}
+}
+
+// parseFieldPath parses a field path given as "a" or ["a", "b"]
+func parseFieldPath(v *structpb.Value) ([]string, error) {
+	switch v.GetKind().(type) {
+	case *structpb.Value_StringValue:
+		if v.GetStringValue() == "" {
+			return nil, fmt.Errorf("empty field")
+		}
+		return []string{v.GetStringValue()}, nil
+	case *structpb.Value_ListValue:
+		path := []string{}
+		for _, field := range v.GetListValue().GetValues() {
+			if _, ok := field.GetKind().(*structpb.Value_StringValue); !ok || field.GetStringValue() == "" {
+				return nil, fmt.Errorf("field path must be a list of non-empty strings")
+			}
+			path = append(path, field.GetStringValue())
+		}
+		if len(path) == 0 {
+			return nil, fmt.Errorf("empty field path")
+		}
+		return path, nil
+	default:
+		return nil, fmt.Errorf("field path must be a string or a list of strings")
+	}
+}
+
+// parsePrimaryKey parses a primary key given as "a" (single field),
+// ["a", "b"] (composite key of top-level fields) or [["a", "b"], ["c"]]
+// (composite key of field paths)
+func parsePrimaryKey(v *structpb.Value) ([][]string, error) {
+	if list, ok := v.GetKind().(*structpb.Value_ListValue); ok {
+		primaryKey := [][]string{}
+		for _, field := range list.ListValue.GetValues() {
+			path, err := parseFieldPath(field)
+			if err != nil {
+				return nil, err
+			}
+			primaryKey = append(primaryKey, path)
+		}
+		if len(primaryKey) == 0 {
+			return nil, fmt.Errorf("empty primary key")
+		}
+		return primaryKey, nil
+	}
+	path, err := parseFieldPath(v)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{path}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
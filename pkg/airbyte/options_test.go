@@ -0,0 +1,168 @@
+package airbyte
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/instill-ai/connector-destination/pkg/configschema"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// testDefinition returns a definition supporting the destination sync modes
+func testDefinition(t *testing.T, id string, modes ...interface{}) *connectorPB.ConnectorDefinition {
+	t.Helper()
+	vendorAttributes, err := structpb.NewStruct(map[string]interface{}{
+		"spec": map[string]interface{}{"supported_destination_sync_modes": modes},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &connectorPB.ConnectorDefinition{Id: id, VendorAttributes: vendorAttributes}
+}
+
+func TestParseConnectionOptions(t *testing.T) {
+	allModes := testDefinition(t, "airbyte-destination-postgres", "append", "overwrite", "append_dedup")
+	noAppend := testDefinition(t, "airbyte-destination-vertica", "overwrite", "append_dedup")
+
+	tests := []struct {
+		name    string
+		def     *connectorPB.ConnectorDefinition
+		config  map[string]interface{}
+		want    connectionOptions
+		wantErr bool
+	}{
+		{
+			name: "default append",
+			def:  allModes,
+			want: connectionOptions{SyncMode: syncModeFullRefresh, DestinationSyncMode: destinationSyncModeAppend},
+		},
+		{
+			name:   "append with cursor",
+			def:    allModes,
+			config: map[string]interface{}{"cursor_field": "data_mapping_index"},
+			want:   connectionOptions{SyncMode: syncModeIncremental, DestinationSyncMode: destinationSyncModeAppend, CursorField: []string{"data_mapping_index"}},
+		},
+		{
+			name:    "append with primary key",
+			def:     allModes,
+			config:  map[string]interface{}{"primary_key": "data_mapping_index"},
+			wantErr: true,
+		},
+		{
+			name:   "overwrite",
+			def:    allModes,
+			config: map[string]interface{}{"destination_sync_mode": "overwrite"},
+			want:   connectionOptions{SyncMode: syncModeFullRefresh, DestinationSyncMode: destinationSyncModeOverwrite},
+		},
+		{
+			name:    "overwrite with cursor",
+			def:     allModes,
+			config:  map[string]interface{}{"destination_sync_mode": "overwrite", "cursor_field": "data_mapping_index"},
+			wantErr: true,
+		},
+		{
+			name:    "overwrite with primary key",
+			def:     allModes,
+			config:  map[string]interface{}{"destination_sync_mode": "overwrite", "primary_key": "data_mapping_index"},
+			wantErr: true,
+		},
+		{
+			name:   "append_dedup with composite primary key",
+			def:    allModes,
+			config: map[string]interface{}{"destination_sync_mode": "append_dedup", "primary_key": []interface{}{"data_mapping_index", "object_index"}},
+			want: connectionOptions{
+				SyncMode:            syncModeIncremental,
+				DestinationSyncMode: destinationSyncModeAppendDedup,
+				PrimaryKey:          [][]string{{"data_mapping_index"}, {"object_index"}},
+			},
+		},
+		{
+			name:   "append_dedup with field path primary key and cursor",
+			def:    allModes,
+			config: map[string]interface{}{"destination_sync_mode": "append_dedup", "primary_key": []interface{}{[]interface{}{"metadata", "id"}}, "cursor_field": []interface{}{"metadata", "updated_at"}},
+			want: connectionOptions{
+				SyncMode:            syncModeIncremental,
+				DestinationSyncMode: destinationSyncModeAppendDedup,
+				CursorField:         []string{"metadata", "updated_at"},
+				PrimaryKey:          [][]string{{"metadata", "id"}},
+			},
+		},
+		{
+			name:    "append_dedup without primary key",
+			def:     allModes,
+			config:  map[string]interface{}{"destination_sync_mode": "append_dedup"},
+			wantErr: true,
+		},
+		{
+			name:    "empty primary key",
+			def:     allModes,
+			config:  map[string]interface{}{"destination_sync_mode": "append_dedup", "primary_key": []interface{}{}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			def:     allModes,
+			config:  map[string]interface{}{"destination_sync_mode": "upsert"},
+			wantErr: true,
+		},
+		{
+			name:    "no default without append",
+			def:     noAppend,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported append",
+			def:     noAppend,
+			config:  map[string]interface{}{"destination_sync_mode": "append"},
+			wantErr: true,
+		},
+		{
+			name:   "overwrite without append",
+			def:    noAppend,
+			config: map[string]interface{}{"destination_sync_mode": "overwrite"},
+			want:   connectionOptions{SyncMode: syncModeFullRefresh, DestinationSyncMode: destinationSyncModeOverwrite},
+		},
+	}
+	for _, tt := range tests {
+		config, err := structpb.NewStruct(tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		destinationConfig, got, err := parseConnectionOptions(tt.def, config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseConnectionOptions() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		tt.want.ValidationPolicy = validationPolicyRejectBatch
+		tt.want.ImagePolicy = imagePolicyDrop
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseConnectionOptions() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		// The connection options are not passed to the destination
+		if len(destinationConfig.GetFields()) != 0 {
+			t.Errorf("%s: destination config = %v, want the options removed", tt.name, destinationConfig)
+		}
+	}
+}
+
+func TestParseConnectionOptionsConfigError(t *testing.T) {
+	def := testDefinition(t, "airbyte-destination-postgres", "append", "overwrite", "append_dedup")
+	config, err := structpb.NewStruct(map[string]interface{}{"destination_sync_mode": "append_dedup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The option errors name the option, as the destination config ones
+	_, _, err = parseConnectionOptions(def, config)
+	var configErr *configschema.ConfigError
+	if !errors.As(err, &configErr) || len(configErr.Errors) != 1 || configErr.Errors[0].Path != "primary_key" {
+		t.Fatalf("parseConnectionOptions() error = %v, want the primary_key ConfigError", err)
+	}
+}
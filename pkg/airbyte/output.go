@@ -0,0 +1,165 @@
+package airbyte
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// TraceError is returned when a destination emits an error AirbyteTraceMessage
+type TraceError struct {
+	Message         string
+	InternalMessage string
+	FailureType     string
+	StackTrace      string
+}
+
+func (e *TraceError) Error() string {
+	msg := e.Message
+	if e.InternalMessage != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.InternalMessage)
+	}
+	if e.FailureType != "" {
+		msg = fmt.Sprintf("%s (failure_type: %s)", msg, e.FailureType)
+	}
+	return msg
+}
+
+// outputParser parses the AirbyteMessages written by a container in JSON
+// Lines format as they arrive. LOG messages are forwarded to the logger,
+// the other message types are collected.
+type outputParser struct {
+	logger *zap.Logger
+	fields []zap.Field
+	buf    []byte
+
+	States           []*AirbyteStateMessage
+	Traces           []*AirbyteTraceMessage
+	Controls         []*AirbyteControlMessage
+	ConnectionStatus *AirbyteConnectionStatus
+	Spec             *ConnectorSpecification
+}
+
+func newOutputParser(logger *zap.Logger, fields ...zap.Field) *outputParser {
+	return &outputParser{logger: logger, fields: fields}
+}
+
+// Write implements io.Writer, each complete line is parsed immediately
+func (p *outputParser) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		p.parseLine(p.buf[:idx])
+		p.buf = p.buf[idx+1:]
+	}
+	return len(b), nil
+}
+
+// Close parses the last line if it is not terminated by a newline
+func (p *outputParser) Close() error {
+	if len(p.buf) > 0 {
+		p.parseLine(p.buf)
+		p.buf = nil
+	}
+	return nil
+}
+
+// Err returns the first error AirbyteTraceMessage as a TraceError, if any
+func (p *outputParser) Err() error {
+	for _, trace := range p.Traces {
+		if trace.Type == AirbyteTraceTypeError && trace.Error != nil {
+			return &TraceError{
+				Message:         trace.Error.Message,
+				InternalMessage: trace.Error.InternalMessage,
+				FailureType:     trace.Error.FailureType,
+				StackTrace:      trace.Error.StackTrace,
+			}
+		}
+	}
+	return nil
+}
+
+func (p *outputParser) parseLine(line []byte) {
+
+	// Lines may end with "\r\n" when the container runs with a TTY
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	abMsg := AirbyteMessage{}
+	if line[0] != '{' || json.Unmarshal(line, &abMsg) != nil || abMsg.Type == "" {
+		// Not an AirbyteMessage, e.g., logs printed by the connector runtime
+		p.logger.Debug(string(line), p.fields...)
+		return
+	}
+
+	switch abMsg.Type {
+	case AirbyteMessageTypeLog:
+		if abMsg.Log != nil {
+			p.log(abMsg.Log)
+		}
+	case AirbyteMessageTypeTrace:
+		if abMsg.Trace != nil {
+			p.Traces = append(p.Traces, abMsg.Trace)
+			switch {
+			case abMsg.Trace.Error != nil:
+				p.logger.Error(fmt.Sprintf("TRACE ERROR: %s", abMsg.Trace.Error.Message),
+					append(p.fields,
+						zap.String("internal_message", abMsg.Trace.Error.InternalMessage),
+						zap.String("failure_type", abMsg.Trace.Error.FailureType))...)
+			case abMsg.Trace.Estimate != nil:
+				p.logger.Debug(fmt.Sprintf("TRACE ESTIMATE: %s", abMsg.Trace.Estimate.Name),
+					append(p.fields,
+						zap.Int64("row_estimate", abMsg.Trace.Estimate.RowEstimate),
+						zap.Int64("byte_estimate", abMsg.Trace.Estimate.ByteEstimate))...)
+			case abMsg.Trace.StreamStatus != nil:
+				p.logger.Debug(fmt.Sprintf("TRACE STREAM_STATUS: %s %s", abMsg.Trace.StreamStatus.StreamDescriptor.Name, abMsg.Trace.StreamStatus.Status),
+					p.fields...)
+			}
+		}
+	case AirbyteMessageTypeState:
+		if abMsg.State != nil {
+			p.States = append(p.States, abMsg.State)
+		}
+	case AirbyteMessageTypeControl:
+		if abMsg.Control != nil {
+			p.Controls = append(p.Controls, abMsg.Control)
+			p.logger.Info(fmt.Sprintf("CONTROL: %s", abMsg.Control.Type), p.fields...)
+		}
+	case AirbyteMessageTypeConnectionStatus:
+		if abMsg.ConnectionStatus != nil {
+			p.ConnectionStatus = abMsg.ConnectionStatus
+		}
+	case AirbyteMessageTypeSpec:
+		if abMsg.Spec != nil {
+			p.Spec = abMsg.Spec
+		}
+	default:
+		p.logger.Debug(fmt.Sprintf("unhandled AirbyteMessage type %s", abMsg.Type), p.fields...)
+	}
+}
+
+// log forwards the AirbyteLogMessage to the logger at the matching level
+func (p *outputParser) log(logMsg *AirbyteLogMessage) {
+	fields := p.fields
+	if logMsg.StackTrace != "" {
+		fields = append(fields, zap.String("stack_trace", logMsg.StackTrace))
+	}
+	switch strings.ToUpper(logMsg.Level) {
+	case "FATAL", "ERROR":
+		p.logger.Error(logMsg.Message, fields...)
+	case "WARN":
+		p.logger.Warn(logMsg.Message, fields...)
+	case "DEBUG", "TRACE":
+		p.logger.Debug(logMsg.Message, fields...)
+	default:
+		p.logger.Info(logMsg.Message, fields...)
+	}
+}
@@ -0,0 +1,143 @@
+package airbyte
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Statuses of an ImageResult
+const (
+	ImageStatusOK      = "ok"
+	ImageStatusSkipped = "skipped"
+	ImageStatusFailed  = "failed"
+)
+
+// defaultPrewarmConcurrency is the number of concurrent pulls by default
+const defaultPrewarmConcurrency = 4
+
+// ImageResult is the outcome of the prewarming of a definition image
+type ImageResult struct {
+	DefinitionUID uuid.UUID
+	DefinitionID  string
+	Image         string
+	Status        string
+	// Reason explains why the image was skipped or failed
+	Reason string
+}
+
+// PrewarmOptions configures PrewarmImages
+type PrewarmOptions struct {
+	// Concurrency bounds the number of concurrent pulls, defaults to 4
+	Concurrency int
+	// Progress, if set, is called as each image completes with the number of
+	// completed images out of the total. The calls are not concurrent.
+	Progress func(result ImageResult, completed int, total int)
+}
+
+// PrewarmImages pulls the images of all the non-tombstoned definitions with
+// bounded concurrency, e.g., at startup, so that the first pipeline runs do
+// not wait for them. Every definition gets a result, in the definition order,
+// the failures not stopping the other pulls.
+func (c *Connector) PrewarmImages(ctx context.Context, options PrewarmOptions) []ImageResult {
+	defs := []*connectorPB.ConnectorDefinition{}
+	seen := map[string]bool{}
+	for _, def := range c.ListConnectorDefinitions() {
+		if def != nil && !seen[def.GetUid()] {
+			seen[def.GetUid()] = true
+			defs = append(defs, def)
+		}
+	}
+	return c.prewarm(ctx, c.Logger, defs, options)
+}
+
+// PreDownloadImage pulls the images of the definitions, see PrewarmImages.
+// The failures are returned joined once all the images are processed.
+func (c *Connector) PreDownloadImage(logger *zap.Logger, uids []uuid.UUID) error {
+	defs := []*connectorPB.ConnectorDefinition{}
+	for _, uid := range uids {
+		def, err := c.GetConnectorDefinitionByUid(uid)
+		if err != nil {
+			logger.Warn(err.Error())
+			continue
+		}
+		defs = append(defs, def)
+	}
+
+	errs := []error{}
+	for _, result := range c.prewarm(context.Background(), logger, defs, PrewarmOptions{}) {
+		if result.Status == ImageStatusFailed {
+			errs = append(errs, fmt.Errorf("%s: %s", result.Image, result.Reason))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// prewarm pulls the images of the definitions
+func (c *Connector) prewarm(ctx context.Context, logger *zap.Logger, defs []*connectorPB.ConnectorDefinition, options PrewarmOptions) []ImageResult {
+
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultPrewarmConcurrency
+	}
+
+	results := make([]ImageResult, len(defs))
+	var mu sync.Mutex
+	completed := 0
+
+	g := errgroup.Group{}
+	g.SetLimit(options.Concurrency)
+	for idx, def := range defs {
+		g.Go(func() error {
+			result := c.prewarmImage(ctx, logger, def)
+			results[idx] = result
+
+			mu.Lock()
+			defer mu.Unlock()
+			completed++
+			if options.Progress != nil {
+				options.Progress(result, completed, len(defs))
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results
+}
+
+// prewarmImage pulls the image of the definition according to the pull policy
+func (c *Connector) prewarmImage(ctx context.Context, logger *zap.Logger, def *connectorPB.ConnectorDefinition) ImageResult {
+
+	result := ImageResult{
+		DefinitionUID: uuid.FromStringOrNil(def.GetUid()),
+		DefinitionID:  def.GetId(),
+		Image:         c.imageName(def),
+	}
+
+	if def.GetTombstone() {
+		result.Status, result.Reason = ImageStatusSkipped, "tombstoned definition"
+		return result
+	}
+	if c.pullPolicy() != PullPolicyAlways {
+		exists, err := c.runtime.ImageExists(ctx, result.Image)
+		if err == nil && exists {
+			result.Status, result.Reason = ImageStatusSkipped, "already present"
+			return result
+		}
+	}
+
+	logger.Info(fmt.Sprintf("download %s", result.Image))
+	if err := c.pullImage(ctx, logger, result.Image); err != nil {
+		logger.Warn(fmt.Sprintf("download %s error: %v", result.Image, err))
+		result.Status, result.Reason = ImageStatusFailed, err.Error()
+		return result
+	}
+	result.Status = ImageStatusOK
+	return result
+}
@@ -0,0 +1,106 @@
+package airbyte
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Image pull policies, as in Kubernetes
+const (
+	// PullPolicyAlways pulls the image before each run
+	PullPolicyAlways = "Always"
+	// PullPolicyIfNotPresent pulls the image only if it is not present
+	PullPolicyIfNotPresent = "IfNotPresent"
+	// PullPolicyNever never pulls the image, which must be present, e.g., in
+	// air-gapped clusters
+	PullPolicyNever = "Never"
+)
+
+// vendorAttributeImageDigest pins the image of a definition by digest, e.g.,
+// sha256:..., instead of the dockerImageTag tag
+const vendorAttributeImageDigest = "dockerImageDigest"
+
+// imageName returns the image of the definition from its mirror if any,
+// pinned by digest if the connector options or the definition set one. A
+// pinned image loaded by LoadImages is run by ID, unless the images are
+// always pulled.
+func (c *Connector) imageName(def *connectorPB.ConnectorDefinition) string {
+	if pinned := c.pinnedImageName(def); pinned != "" {
+		if id, ok := c.loadedImage(pinned); ok && c.pullPolicy() != PullPolicyAlways {
+			return id
+		}
+		return pinned
+	}
+	fields := def.GetVendorAttributes().GetFields()
+	return fmt.Sprintf("%s:%s", c.mirrorRepository(fields["dockerRepository"].GetStringValue()), fields["dockerImageTag"].GetStringValue())
+}
+
+// pinnedImageName returns the image of the definition pinned by digest,
+// empty if the definition is not pinned
+func (c *Connector) pinnedImageName(def *connectorPB.ConnectorDefinition) string {
+	fields := def.GetVendorAttributes().GetFields()
+	digest := c.options.ImageDigests[def.GetId()]
+	if digest == "" {
+		digest = fields[vendorAttributeImageDigest].GetStringValue()
+	}
+	if digest == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", c.mirrorRepository(fields["dockerRepository"].GetStringValue()), digest)
+}
+
+// pullPolicy returns the pull policy of the connector, IfNotPresent by default
+func (c *Connector) pullPolicy() string {
+	if c.options.PullPolicy == "" {
+		return PullPolicyIfNotPresent
+	}
+	return c.options.PullPolicy
+}
+
+// pullImage makes the image available according to the pull policy, retrying
+// the transient failures. Concurrent pulls of the same image are collapsed
+// into one, each caller waiting for it until its context is done.
+func (c *Connector) pullImage(ctx context.Context, logger *zap.Logger, imageName string) error {
+
+	switch policy := c.pullPolicy(); policy {
+	case PullPolicyAlways:
+	case PullPolicyIfNotPresent, PullPolicyNever:
+		exists, err := c.runtime.ImageExists(ctx, imageName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		if policy == PullPolicyNever {
+			return fmt.Errorf("image %s is not present and the pull policy is %s", imageName, policy)
+		}
+	default:
+		return fmt.Errorf("unknown pull policy %s", policy)
+	}
+
+	// The shared pull is not cancelled with the context of its first caller
+	pullCtx := context.WithoutCancel(ctx)
+	result := c.pulls.DoChan(imageName, func() (interface{}, error) {
+		credential, err := c.registryCredential(pullCtx, imageName)
+		if err != nil {
+			return nil, err
+		}
+		return nil, retry(pullCtx, logger, c.options.RetryPolicy, fmt.Sprintf("pull %s", imageName), func() error {
+			return c.runtime.PullImage(pullCtx, imageName, credential)
+		})
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r := <-result:
+		if r.Shared {
+			logger.Debug(fmt.Sprintf("pull %s shared with concurrent callers", imageName))
+		}
+		return r.Err
+	}
+}
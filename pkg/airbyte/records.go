@@ -0,0 +1,173 @@
+package airbyte
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+const taskUnspecified = "unspecified"
+
+// Fields of the row-per-object records
+const (
+	objectsField     = "objects"
+	objectIndexField = "object_index"
+)
+
+// Fields of the DataPayload texts and audios, written with each task output
+const (
+	textsField  = "texts"
+	audiosField = "audios"
+)
+
+// objectTasks are the tasks outputting a list of objects, which can be
+// flattened into row-per-object records
+var objectTasks = []string{"detection", "keypoint", "ocr", "instance_segmentation"}
+
+func isObjectTask(taskName string) bool {
+	return contains(objectTasks, taskName)
+}
+
+// objectStreamName returns the name of the row-per-object stream of a task
+func objectStreamName(taskName string) string {
+	return taskName + "_" + objectsField
+}
+
+// taskRecord holds the data of an AirbyteRecordMessage before marshalling
+type taskRecord struct {
+	Stream string
+	Data   map[string]interface{}
+}
+
+// payloadTasks returns the tasks of the outputs held by the DataPayload
+// structured data. A DataPayload without task output is unspecified.
+func payloadTasks(dataPayload *connectorPB.DataPayload) []string {
+	tasks := []string{}
+	fields := dataPayload.GetStructuredData().GetFields()
+	for _, taskName := range taskNames {
+		if _, ok := fields[taskName].GetKind().(*structpb.Value_StructValue); ok {
+			tasks = append(tasks, taskName)
+		}
+	}
+	if len(tasks) == 0 {
+		tasks = append(tasks, taskUnspecified)
+	}
+	return tasks
+}
+
+// payloadStreams returns the streams the DataPayload records are written to
+func payloadStreams(dataPayload *connectorPB.DataPayload, flattenObjects bool) []string {
+	streams := []string{}
+	for _, taskName := range payloadTasks(dataPayload) {
+		if flattenObjects && isObjectTask(taskName) {
+			streams = append(streams, objectStreamName(taskName))
+		} else {
+			streams = append(streams, taskName)
+		}
+	}
+	return streams
+}
+
+// batchStreams returns the streams of the tasks of all the DataPayloads, in
+// the order they first appear
+func batchStreams(dataPayloads []*connectorPB.DataPayload, flattenObjects bool) []string {
+	streams := []string{}
+	for _, dataPayload := range dataPayloads {
+		for _, stream := range payloadStreams(dataPayload, flattenObjects) {
+			if !contains(streams, stream) {
+				streams = append(streams, stream)
+			}
+		}
+	}
+	return streams
+}
+
+// buildRecords builds the records of a DataPayload, one per task output, to
+// be written to the stream of the task. If flattenObjects is set, the
+// object-level task outputs are written one record per object instead, each
+// record holding the object index and the flattened object fields. The
+// DataPayload fields, see addPayloadFields, are added to each record.
+func buildRecords(dataPayload *connectorPB.DataPayload, flattenObjects bool, payloadImages []interface{}) []taskRecord {
+
+	structuredData := dataPayload.GetStructuredData().AsMap()
+
+	records := []taskRecord{}
+	for _, taskName := range payloadTasks(dataPayload) {
+		if flattenObjects && isObjectTask(taskName) {
+			output, _ := structuredData[taskName].(map[string]interface{})
+			objects, _ := output[objectsField].([]interface{})
+			for objectIdx, object := range objects {
+				data := map[string]interface{}{}
+				if object, ok := object.(map[string]interface{}); ok {
+					flattenObject(object, "", data)
+				}
+				data[objectIndexField] = objectIdx
+				addPayloadFields(data, dataPayload, payloadImages)
+				records = append(records, taskRecord{Stream: objectStreamName(taskName), Data: data})
+			}
+			continue
+		}
+
+		data := map[string]interface{}{}
+		if output, ok := structuredData[taskName].(map[string]interface{}); ok {
+			for key, value := range output {
+				data[key] = value
+			}
+		} else {
+			// Unspecified output, keep the structured data as is
+			for key, value := range structuredData {
+				data[key] = value
+			}
+		}
+		addPayloadFields(data, dataPayload, payloadImages)
+		records = append(records, taskRecord{Stream: taskName, Data: data})
+	}
+	return records
+}
+
+// addPayloadFields adds the DataPayload fields written with each task output
+// to the record data: the data_mapping_index, the metadata, the texts, the
+// audios in base64 and the record values of the images, if any
+func addPayloadFields(data map[string]interface{}, dataPayload *connectorPB.DataPayload, payloadImages []interface{}) {
+	data["data_mapping_index"] = dataPayload.GetDataMappingIndex()
+	if dataPayload.GetMetadata() != nil {
+		data["metadata"] = dataPayload.GetMetadata().AsMap()
+	}
+	if len(dataPayload.GetTexts()) > 0 {
+		texts := []interface{}{}
+		for _, text := range dataPayload.GetTexts() {
+			texts = append(texts, text)
+		}
+		data[textsField] = texts
+	}
+	if len(dataPayload.GetAudios()) > 0 {
+		audios := []interface{}{}
+		for _, audio := range dataPayload.GetAudios() {
+			audios = append(audios, base64.StdEncoding.EncodeToString(audio))
+		}
+		data[audiosField] = audios
+	}
+	if len(payloadImages) > 0 {
+		data[payloadImagesField] = payloadImages
+	}
+}
+
+// marshal returns the record data in JSON
+func (r taskRecord) marshal() (json.RawMessage, error) {
+	return json.Marshal(r.Data)
+}
+
+// flattenObject adds the fields of the object to flattened, the fields of
+// nested objects being prefixed by their key, e.g., bounding_box_left
+func flattenObject(object map[string]interface{}, prefix string, flattened map[string]interface{}) {
+	for key, value := range object {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenObject(nested, prefix+key+"_", flattened)
+			continue
+		}
+		flattened[prefix+key] = value
+	}
+}
@@ -0,0 +1,153 @@
+package airbyte
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// dockerHubAuthKey is the key of the Docker Hub credentials in the docker
+// config.json files
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// RegistryCredential authenticates the image pulls from a registry
+type RegistryCredential struct {
+	Username string
+	Password string
+	// IdentityToken is used instead of the username and password, e.g., an
+	// OAuth refresh token
+	IdentityToken string
+}
+
+// RegistryAuth provides the credentials of the image registries by host,
+// e.g., registry.internal:5000 or docker.io. The static credentials are used
+// first, then the credential helpers, then the docker config.json.
+type RegistryAuth struct {
+	// Credentials are static credentials per registry host
+	Credentials map[string]RegistryCredential
+	// CredentialHelpers are the docker-credential-<helper> programs per
+	// registry host, e.g., ecr-login
+	CredentialHelpers map[string]string
+	// DockerConfigPath is a docker config.json whose auths, credHelpers and
+	// credsStore are used, e.g., /root/.docker/config.json
+	DockerConfigPath string
+}
+
+// dockerConfig holds the credential fields of a docker config.json
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// mirrorRepository rewrites the repository with the longest matching prefix
+// of the RegistryMirrors option, e.g., airbyte/ to registry.internal/airbyte/
+func (c *Connector) mirrorRepository(repository string) string {
+	prefixes := []string{}
+	for prefix := range c.options.RegistryMirrors {
+		if strings.HasPrefix(repository, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return repository
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return c.options.RegistryMirrors[prefixes[0]] + strings.TrimPrefix(repository, prefixes[0])
+}
+
+// registryCredential returns the credential of the image registry, nil if
+// none is configured
+func (c *Connector) registryCredential(ctx context.Context, imageName string) (*RegistryCredential, error) {
+
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", imageName, err)
+	}
+	host := reference.Domain(named)
+	auth := c.options.RegistryAuth
+
+	if credential, ok := auth.Credentials[host]; ok {
+		return &credential, nil
+	}
+	if helper, ok := auth.CredentialHelpers[host]; ok {
+		return credentialFromHelper(ctx, helper, host)
+	}
+	if auth.DockerConfigPath == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(auth.DockerConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("read docker config %s error: %w", auth.DockerConfigPath, err)
+	}
+	config := dockerConfig{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parse docker config %s error: %w", auth.DockerConfigPath, err)
+	}
+
+	serverURL := host
+	if host == "docker.io" {
+		serverURL = dockerHubAuthKey
+	}
+	if entry, ok := config.Auths[serverURL]; ok && (entry.Auth != "" || entry.IdentityToken != "") {
+		credential := &RegistryCredential{IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s in docker config: %w", serverURL, err)
+			}
+			credential.Username, credential.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return credential, nil
+	}
+	if helper, ok := config.CredHelpers[host]; ok {
+		return credentialFromHelper(ctx, helper, serverURL)
+	}
+	if config.CredsStore != "" {
+		return credentialFromHelper(ctx, config.CredsStore, serverURL)
+	}
+	return nil, nil
+}
+
+// credentialFromHelper runs `docker-credential-<helper> get`, nil being
+// returned if the helper has no credential for the server
+func credentialFromHelper(ctx context.Context, helper string, serverURL string) (*RegistryCredential, error) {
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// The helpers print the message on stdout
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %s error: %v: %s", helper, err, message)
+	}
+
+	output := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("credential helper %s error: %w", helper, err)
+	}
+	// The identity tokens are returned with the <token> username
+	if output.Username == "<token>" {
+		return &RegistryCredential{IdentityToken: output.Secret}, nil
+	}
+	return &RegistryCredential{Username: output.Username, Password: output.Secret}, nil
+}
@@ -0,0 +1,159 @@
+package airbyte
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Airbyte job types used in the `resourceRequirements.jobSpecific` vendor attribute
+const (
+	jobTypeSync  = "sync"
+	jobTypeCheck = "check_connection"
+	jobTypeSpec  = "spec"
+)
+
+// ResourceRequirements defines the compute resources of a container job as in
+// the `resourceRequirements` vendor attribute of the Airbyte definitions
+type ResourceRequirements struct {
+	CPURequest    string `json:"cpu_request,omitempty"`
+	CPULimit      string `json:"cpu_limit,omitempty"`
+	MemoryRequest string `json:"memory_request,omitempty"`
+	MemoryLimit   string `json:"memory_limit,omitempty"`
+}
+
+// merge overrides the requirements with the non-empty values of other
+func (r ResourceRequirements) merge(other ResourceRequirements) ResourceRequirements {
+	if other.CPURequest != "" {
+		r.CPURequest = other.CPURequest
+	}
+	if other.CPULimit != "" {
+		r.CPULimit = other.CPULimit
+	}
+	if other.MemoryRequest != "" {
+		r.MemoryRequest = other.MemoryRequest
+	}
+	if other.MemoryLimit != "" {
+		r.MemoryLimit = other.MemoryLimit
+	}
+	return r
+}
+
+// getResourceRequirements returns the resource requirements of the definition
+// for the given job type, the job specific values override the default ones
+func getResourceRequirements(def *connectorPB.ConnectorDefinition, jobType string) ResourceRequirements {
+
+	parse := func(fields map[string]interface{}) ResourceRequirements {
+		r := ResourceRequirements{}
+		r.CPURequest, _ = fields["cpu_request"].(string)
+		r.CPULimit, _ = fields["cpu_limit"].(string)
+		r.MemoryRequest, _ = fields["memory_request"].(string)
+		r.MemoryLimit, _ = fields["memory_limit"].(string)
+		return r
+	}
+
+	requirements := ResourceRequirements{}
+	resourceRequirements := def.GetVendorAttributes().GetFields()["resourceRequirements"].GetStructValue().AsMap()
+
+	if defaultRequirements, ok := resourceRequirements["default"].(map[string]interface{}); ok {
+		requirements = requirements.merge(parse(defaultRequirements))
+	}
+	if jobSpecific, ok := resourceRequirements["jobSpecific"].([]interface{}); ok {
+		for _, v := range jobSpecific {
+			job, ok := v.(map[string]interface{})
+			if !ok || job["jobType"] != jobType {
+				continue
+			}
+			if jobRequirements, ok := job["resourceRequirements"].(map[string]interface{}); ok {
+				requirements = requirements.merge(parse(jobRequirements))
+			}
+		}
+	}
+	return requirements
+}
+
+// ResourceCaps bounds the compute resources of every container job, whatever
+// the definition requirements. The values are Kubernetes quantities, e.g., 2
+// or 500m for CPU and 2Gi for memory.
+type ResourceCaps struct {
+	CPU    string
+	Memory string
+}
+
+// javaHeapRatio is the share of the memory limit given to the JVM heap, the
+// remainder being left to the metaspace, the threads and the native memory
+const javaHeapRatio = 0.75
+
+// capped bounds the requests and limits by the caps, the missing limits
+// being set to the caps
+func (r ResourceRequirements) capped(caps ResourceCaps) (ResourceRequirements, error) {
+	var err error
+	for _, c := range []struct {
+		value *string
+		cap   string
+	}{
+		{&r.CPURequest, caps.CPU},
+		{&r.CPULimit, caps.CPU},
+		{&r.MemoryRequest, caps.Memory},
+		{&r.MemoryLimit, caps.Memory},
+	} {
+		if *c.value, err = minQuantity(*c.value, c.cap); err != nil {
+			return r, err
+		}
+	}
+	if r.CPULimit == "" {
+		r.CPULimit = caps.CPU
+	}
+	if r.MemoryLimit == "" {
+		r.MemoryLimit = caps.Memory
+	}
+	return r, nil
+}
+
+// minQuantity returns the smallest quantity, an empty value being unbounded
+func minQuantity(value, max string) (string, error) {
+	if value == "" || max == "" {
+		return value, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid resource requirement %s: %w", value, err)
+	}
+	m, err := resource.ParseQuantity(max)
+	if err != nil {
+		return "", fmt.Errorf("invalid resource cap %s: %w", max, err)
+	}
+	if q.Cmp(m) > 0 {
+		return max, nil
+	}
+	return value, nil
+}
+
+// javaOptions returns the JAVA_OPTS environment variable sizing the JVM heap
+// from the memory limit, nil without limit
+func javaOptions(requirements ResourceRequirements) ([]string, error) {
+	if requirements.MemoryLimit == "" {
+		return nil, nil
+	}
+	limit, err := resource.ParseQuantity(requirements.MemoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource requirement %s: %w", requirements.MemoryLimit, err)
+	}
+	heapMiB := int64(float64(limit.Value())*javaHeapRatio) / (1 << 20)
+	if heapMiB <= 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("JAVA_OPTS=-XX:+ExitOnOutOfMemoryError -Xmx%dm", heapMiB)}, nil
+}
+
+// jobResources returns the resource requirements of the definition job
+// bounded by the connector caps
+func (c *Connector) jobResources(def *connectorPB.ConnectorDefinition, jobType string) (ResourceRequirements, error) {
+	requirements, err := getResourceRequirements(def, jobType).capped(c.options.ResourceCaps)
+	if err != nil {
+		return requirements, fmt.Errorf("%s %s resources error: %w", def.GetId(), jobType, err)
+	}
+	return requirements, nil
+}
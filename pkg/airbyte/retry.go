@@ -0,0 +1,157 @@
+package airbyte
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"go.uber.org/zap"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RetryPolicy defines how the image pulls, the container creations and
+// starts, and the writes failing with a transient error are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 1
+	// disables the retries
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts
+	MaxBackoff time.Duration
+	// Multiplier increases the delay after each retry
+	Multiplier float64
+	// Jitter randomises each delay by up to the given fraction, e.g., 0.2
+	Jitter float64
+	// Retryable classifies the errors, defaults to IsTransientError
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy is used for the zero RetryPolicy fields
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Retryable:      IsTransientError,
+}
+
+// withDefaults returns the policy with the zero fields set to the defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Retryable == nil {
+		p.Retryable = DefaultRetryPolicy.Retryable
+	}
+	return p
+}
+
+// backoff returns the delay before the given retry, starting from 1
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	delay = math.Min(delay, float64(p.MaxBackoff))
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// IsTransientError reports whether err is worth retrying: the image pulls,
+// container creations and starts failing for another reason than an invalid
+// request, and the TRACE errors with the transient_error failure type
+func IsTransientError(err error) bool {
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var traceErr *TraceError
+	if errors.As(err, &traceErr) {
+		return traceErr.FailureType == FailureTypeTransientError
+	}
+
+	// The operation already used its attempts
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return false
+	}
+
+	var containerErr *ContainerError
+	if errors.As(err, &containerErr) {
+		cause := containerErr.Err
+		switch {
+		case errdefs.IsNotFound(cause), errdefs.IsInvalidParameter(cause),
+			errdefs.IsUnauthorized(cause), errdefs.IsForbidden(cause):
+			return false
+		case apierrors.IsInvalid(cause), apierrors.IsBadRequest(cause),
+			apierrors.IsUnauthorized(cause), apierrors.IsForbidden(cause):
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+// RetryError is returned once an operation used all its attempts
+type RetryError struct {
+	Op       string
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s failed after %d attempts: %v", e.Op, e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// retry runs fn until it succeeds, fails with a non-retryable error or
+// reaches the maximum number of attempts. Each failed attempt is logged.
+func retry(ctx context.Context, logger *zap.Logger, policy RetryPolicy, op string, fn func() error) error {
+
+	policy = policy.withDefaults()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if !policy.Retryable(err) {
+			return err
+		}
+		if attempt >= policy.MaxAttempts {
+			return &RetryError{Op: op, Attempts: attempt, Err: err}
+		}
+
+		delay := policy.backoff(attempt)
+		logger.Warn(fmt.Sprintf("%s attempt %d/%d failed, retrying in %s: %v", op, attempt, policy.MaxAttempts, delay.Round(time.Millisecond), err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package airbyte
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ContainerRuntime defines the interface used by the connector to run the
+// Airbyte destination images. The Docker implementation is used by default,
+// other runtimes (e.g., Podman, Kubernetes or an in-memory fake) can be
+// supplied through ConnectorOptions.
+type ContainerRuntime interface {
+	// PullImage makes the image available to the runtime, the credential
+	// being nil for the anonymous pulls
+	PullImage(ctx context.Context, imageName string, credential *RegistryCredential) error
+	// ImageExists reports whether the image is available to the runtime
+	ImageExists(ctx context.Context, imageName string) (bool, error)
+	// ImageDigest returns the digest identifying the image content, or an
+	// empty string if the runtime cannot resolve it
+	ImageDigest(ctx context.Context, imageName string) (string, error)
+	// Run creates a container for the job, runs it to completion and removes it
+	Run(ctx context.Context, job *ContainerJob) error
+}
+
+// ContainerMount defines a volume (or a host path if Source is absolute)
+// mounted into the container
+type ContainerMount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ContainerFile defines a file made available to the container at Path
+type ContainerFile struct {
+	Path    string
+	Content []byte
+	// Secret marks the files holding credentials, e.g., the connector config
+	Secret bool
+}
+
+// ContainerJob defines a single run of an Airbyte destination image
+type ContainerJob struct {
+	Name   string
+	Image  string
+	Cmd    []string
+	Mounts []ContainerMount
+	Files  []ContainerFile
+
+	// Env holds the environment variables in the KEY=value form
+	Env []string
+
+	// Resources holds the compute resources requested for the job
+	Resources ResourceRequirements
+	// Security holds the privileges of the container
+	Security SecurityProfile
+	// PullPolicy is used by the runtimes pulling the images themselves
+	PullPolicy string
+
+	// Stdin, if set, is streamed into the container standard input
+	Stdin io.Reader
+	// Stdout and Stderr, if set, receive the container outputs
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Operations reported by ContainerError
+const (
+	ContainerOpPull   = "pull"
+	ContainerOpCreate = "create"
+	ContainerOpStart  = "start"
+)
+
+// ContainerError is returned by the runtimes when an image pull, a container
+// creation or a container start fails, hence before the destination runs
+type ContainerError struct {
+	Op    string
+	Image string
+	Err   error
+}
+
+func (e *ContainerError) Error() string {
+	if e.Op == ContainerOpPull {
+		return fmt.Sprintf("pull image %s error: %v", e.Image, e.Err)
+	}
+	return fmt.Sprintf("%s container of image %s error: %v", e.Op, e.Image, e.Err)
+}
+
+func (e *ContainerError) Unwrap() error {
+	return e.Err
+}
+
+// ExitError is returned when the container exits with a non-zero code
+type ExitError struct {
+	Image     string
+	Container string
+	ExitCode  int64
+	// StderrTail holds the last lines of the container standard error
+	StderrTail string
+}
+
+func (e *ExitError) Error() string {
+	if e.StderrTail == "" {
+		return fmt.Sprintf("container %s of image %s exited with code %d", e.Container, e.Image, e.ExitCode)
+	}
+	return fmt.Sprintf("container %s of image %s exited with code %d: %s", e.Container, e.Image, e.ExitCode, e.StderrTail)
+}
+
+// Limits of the stderr tail reported by ExitError
+const (
+	stderrTailLines = 20
+	stderrTailBytes = 4096
+)
+
+// inputReader records the first error of the job Stdin other than EOF. The
+// stop function is called before the error is returned so that the container
+// is stopped before it may read EOF and commit a partial input.
+type inputReader struct {
+	r    io.Reader
+	stop func()
+
+	mu  sync.Mutex
+	err error
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.mu.Lock()
+		first := r.err == nil
+		if first {
+			r.err = err
+		}
+		r.mu.Unlock()
+		if first {
+			r.stop()
+		}
+	}
+	return n, err
+}
+
+// Err returns the error of the job Stdin, if any
+func (r *inputReader) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// tailWriter keeps the last bytes written to it
+type tailWriter struct {
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > stderrTailBytes {
+		w.buf = w.buf[len(w.buf)-stderrTailBytes:]
+	}
+	return len(p), nil
+}
+
+// String returns the last lines written
+func (w *tailWriter) String() string {
+	lines := strings.Split(strings.TrimSpace(string(w.buf)), "\n")
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
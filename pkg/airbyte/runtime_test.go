@@ -0,0 +1,95 @@
+package airbyte
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+// Definitions of the seed used by the tests
+var (
+	postgresDefUID  = uuid.FromStringOrNil("25c5221d-dce2-4163-ade9-739ef790f503")
+	localJSONDefUID = uuid.FromStringOrNil("a625d593-bba5-4a1c-a53d-2d246268a816")
+)
+
+// fakeRun is a job run by a fakeRuntime
+type fakeRun struct {
+	Job   *ContainerJob
+	Stdin []byte
+}
+
+// fakeRuntime is an in-memory ContainerRuntime, the container outputs being
+// written by its run function
+type fakeRuntime struct {
+	run func(job *ContainerJob, stdin []byte) error
+
+	mu   sync.Mutex
+	runs []fakeRun
+}
+
+func (r *fakeRuntime) PullImage(ctx context.Context, imageName string, credential *RegistryCredential) error {
+	return nil
+}
+
+func (r *fakeRuntime) ImageExists(ctx context.Context, imageName string) (bool, error) {
+	return true, nil
+}
+
+func (r *fakeRuntime) ImageDigest(ctx context.Context, imageName string) (string, error) {
+	return "sha256:" + imageName, nil
+}
+
+func (r *fakeRuntime) Run(ctx context.Context, job *ContainerJob) error {
+	var stdin []byte
+	if job.Stdin != nil {
+		b, err := io.ReadAll(job.Stdin)
+		if err != nil {
+			return err
+		}
+		stdin = b
+	}
+	r.mu.Lock()
+	r.runs = append(r.runs, fakeRun{Job: job, Stdin: stdin})
+	r.mu.Unlock()
+	if r.run == nil {
+		return nil
+	}
+	return r.run(job, stdin)
+}
+
+// Runs returns the jobs run so far
+func (r *fakeRuntime) Runs() []fakeRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fakeRun{}, r.runs...)
+}
+
+// newTestConnector returns a Connector of the seed definitions running the
+// jobs with the runtime
+func newTestConnector(t *testing.T, runtime ContainerRuntime, options ConnectorOptions) *Connector {
+	t.Helper()
+	options.ContainerRuntime = runtime
+	options.VDPProtocolPath = "../../vdp_protocol.yaml"
+	if options.MountTargetVDP == "" {
+		options.MountSourceVDP = t.TempDir()
+		options.MountTargetVDP = options.MountSourceVDP
+	}
+	options.RetryPolicy = RetryPolicy{MaxAttempts: 1}
+	return newConnector(zap.NewNop(), options)
+}
+
+// writeMessages writes the AirbyteMessages in JSON Lines format
+func writeMessages(w io.Writer, messages ...AirbyteMessage) error {
+	encoder := json.NewEncoder(w)
+	for _, message := range messages {
+		if err := encoder.Encode(&message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
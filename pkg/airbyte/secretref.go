@@ -0,0 +1,216 @@
+package airbyte
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Schemes of the secret references of the credential fields, e.g.,
+// env:PG_PASSWORD, file:/run/secrets/pg or vault:secret/data/pg#password
+const (
+	secretSchemeEnv  = "env"
+	secretSchemeFile = "file"
+)
+
+// SecretProvider resolves the secret references of a scheme
+type SecretProvider interface {
+	// Resolve returns the secret referenced by ref, i.e., the reference
+	// without its scheme prefix
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// EnvSecretProvider resolves the references to the allowed environment
+// variables
+type EnvSecretProvider struct {
+	// Allowed are the names of the variables, a name ending with * being a
+	// prefix, e.g., VDP_SECRET_*
+	Allowed []string
+}
+
+// Resolve returns the value of the environment variable named ref
+func (p *EnvSecretProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	allowed := false
+	for _, name := range p.Allowed {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			allowed = allowed || strings.HasPrefix(ref, prefix)
+		} else {
+			allowed = allowed || ref == name
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("environment variable %s is not allowed", ref)
+	}
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+// FileSecretProvider resolves the references to the files of a directory,
+// e.g., Docker or Kubernetes secrets mounted under /run/secrets
+type FileSecretProvider struct {
+	// BaseDir is the directory of the files, the paths outside it being
+	// rejected once cleaned and their symbolic links resolved
+	BaseDir string
+}
+
+// Resolve returns the content of the file at path ref, relative to BaseDir
+// or absolute, without the trailing newline
+func (p *FileSecretProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	if p.BaseDir == "" {
+		return "", fmt.Errorf("no secret file directory")
+	}
+	baseDir, err := filepath.EvalSymlinks(filepath.Clean(p.BaseDir))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Clean(ref)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.BaseDir, path)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(baseDir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("secret file %s is outside %s", ref, p.BaseDir)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// HTTPSecretProviderOptions configures a HTTPSecretProvider
+type HTTPSecretProviderOptions struct {
+	// Address is the base URL of the secret store, e.g., https://vault:8200
+	Address string
+	// Token is sent in the X-Vault-Token header, if set
+	Token string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// HTTPSecretProvider resolves Vault-style path#key references by reading the
+// secret at <Address>/v1/<path> and returning its key. Both the KV version 1
+// ({"data": {...}}) and version 2 ({"data": {"data": {...}}}) responses are
+// supported.
+type HTTPSecretProvider struct {
+	options HTTPSecretProviderOptions
+}
+
+// NewHTTPSecretProvider returns a SecretProvider backed by a Vault-compatible
+// HTTP API
+func NewHTTPSecretProvider(options HTTPSecretProviderOptions) *HTTPSecretProvider {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	return &HTTPSecretProvider{options: options}
+}
+
+// Resolve returns the key of the secret at path, ref being path#key
+func (p *HTTPSecretProvider) Resolve(ctx context.Context, ref string) (string, error) {
+
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("secret reference %s must be path#key", ref)
+	}
+
+	secretURL, err := url.JoinPath(p.options.Address, "v1", path)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if p.options.Token != "" {
+		req.Header.Set("X-Vault-Token", p.options.Token)
+	}
+
+	resp, err := p.options.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("read secret %s error: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("read secret %s error: %w", path, err)
+	}
+
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string key %s", path, key)
+	}
+	return value, nil
+}
+
+// defaultSecretProviders returns the providers of the connector, the env and
+// file providers being available only if the options allow some variables
+// or a directory, unless overridden
+func defaultSecretProviders(options ConnectorOptions) map[string]SecretProvider {
+	resolved := map[string]SecretProvider{}
+	if len(options.SecretEnvAllowlist) > 0 {
+		resolved[secretSchemeEnv] = &EnvSecretProvider{Allowed: options.SecretEnvAllowlist}
+	}
+	if options.SecretFileDir != "" {
+		resolved[secretSchemeFile] = &FileSecretProvider{BaseDir: options.SecretFileDir}
+	}
+	for scheme, provider := range options.SecretProviders {
+		resolved[scheme] = provider
+	}
+	return resolved
+}
+
+// resolveSecretRefs returns a copy of the config with the secret references
+// of the credential fields replaced by their values, and the resolved values.
+// The values not prefixed by the scheme of a provider are kept as is.
+func resolveSecretRefs(ctx context.Context, config *structpb.Struct, credentialFields []string, providers map[string]SecretProvider) (*structpb.Struct, []string, error) {
+
+	if config == nil {
+		return nil, nil, nil
+	}
+
+	resolved := proto.Clone(config).(*structpb.Struct)
+	values := []string{}
+	for _, field := range credentialFields {
+		value := fieldValue(resolved, field)
+		scheme, ref, ok := strings.Cut(value.GetStringValue(), ":")
+		if !ok {
+			continue
+		}
+		provider, ok := providers[scheme]
+		if !ok {
+			continue
+		}
+		secret, err := provider.Resolve(ctx, ref)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolve %s secret reference of %s error: %w", scheme, field, err)
+		}
+		value.Kind = &structpb.Value_StringValue{StringValue: secret}
+		values = append(values, secret)
+	}
+	return resolved, values, nil
+}
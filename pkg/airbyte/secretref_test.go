@@ -0,0 +1,123 @@
+package airbyte
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestHTTPSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/kv1":
+			_, _ = w.Write([]byte(`{"data": {"password": "kv1-secret"}}`))
+		case "/v1/secret/data/kv2":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "kv2-secret"}, "metadata": {"version": 1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewHTTPSecretProvider(HTTPSecretProviderOptions{Address: server.URL, Token: "token"})
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "secret/kv1#password", want: "kv1-secret"},
+		{ref: "secret/data/kv2#password", want: "kv2-secret"},
+		{ref: "secret/kv1#username", wantErr: true},
+		{ref: "secret/missing#password", wantErr: true},
+		{ref: "secret/kv1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := provider.Resolve(context.Background(), tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%s) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%s) = %s, want %s", tt.ref, got, tt.want)
+		}
+	}
+
+	unauthenticated := NewHTTPSecretProvider(HTTPSecretProviderOptions{Address: server.URL})
+	if _, err := unauthenticated.Resolve(context.Background(), "secret/kv1#password"); err == nil {
+		t.Errorf("Resolve without token succeeded")
+	}
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("VDP_SECRET_PG", "pg-secret")
+	t.Setenv("PG_PASSWORD", "pg-password")
+	t.Setenv("LEAK", "topsecret")
+
+	provider := &EnvSecretProvider{Allowed: []string{"VDP_SECRET_*", "PG_PASSWORD"}}
+	for ref, want := range map[string]string{"VDP_SECRET_PG": "pg-secret", "PG_PASSWORD": "pg-password"} {
+		got, err := provider.Resolve(context.Background(), ref)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %s, %v, want %s", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"LEAK", "PG_PASSWORD_2", "VDP_SECRET"} {
+		if _, err := provider.Resolve(context.Background(), ref); err == nil {
+			t.Errorf("Resolve(%s) succeeded", ref)
+		}
+	}
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	baseDir := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(baseDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "pg"), []byte("pg-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "outside"), []byte("topsecret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(baseDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &FileSecretProvider{BaseDir: baseDir}
+	for _, ref := range []string{"pg", filepath.Join(baseDir, "pg")} {
+		got, err := provider.Resolve(context.Background(), ref)
+		if err != nil || got != "pg-secret" {
+			t.Errorf("Resolve(%s) = %s, %v, want pg-secret", ref, got, err)
+		}
+	}
+	for _, ref := range []string{"../outside", filepath.Join(dir, "outside"), "link", "/proc/self/environ"} {
+		if _, err := provider.Resolve(context.Background(), ref); err == nil {
+			t.Errorf("Resolve(%s) succeeded", ref)
+		}
+	}
+}
+
+func TestResolveSecretRefsDisabledByDefault(t *testing.T) {
+	t.Setenv("LEAK", "topsecret")
+
+	config, err := structpb.NewStruct(map[string]interface{}{"password": "env:LEAK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, values, err := resolveSecretRefs(context.Background(), config, []string{"password"}, defaultSecretProviders(ConnectorOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resolved.GetFields()["password"].GetStringValue(); got != "env:LEAK" || len(values) != 0 {
+		t.Errorf("password = %s, want env:LEAK unresolved", got)
+	}
+}
@@ -0,0 +1,177 @@
+package airbyte
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const maskedValue = "******"
+
+// staleFileAge is the age after which the files left in the connector data
+// folders, e.g., by a crashed process, are removed. The destinations read
+// their config and catalog when they start, hence long before.
+const staleFileAge = time.Hour
+
+// credentialValues returns the string values of the config credential
+// fields, the fields being given as dot-separated paths as in
+// ListCredentialField
+func credentialValues(config *structpb.Struct, credentialFields []string) []string {
+	values := []string{}
+	for _, field := range credentialFields {
+		if v := fieldValue(config, field).GetStringValue(); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// fieldValue returns the value at the dot-separated path, nil if not found
+func fieldValue(config *structpb.Struct, field string) *structpb.Value {
+	value := structpb.NewStructValue(config)
+	for _, key := range strings.Split(field, ".") {
+		value = value.GetStructValue().GetFields()[key]
+	}
+	return value
+}
+
+// secretSet holds the secrets of a connection. Resolved secret references
+// are added when the config is materialised.
+type secretSet struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+func newSecretSet(values ...string) *secretSet {
+	s := &secretSet{}
+	s.add(values...)
+	return s
+}
+
+func (s *secretSet) add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, value := range values {
+		if value != "" && !contains(s.values, value) {
+			s.values = append(s.values, value)
+		}
+	}
+	// Mask the longest values first in case a value contains another one
+	sort.Slice(s.values, func(i, j int) bool { return len(s.values[i]) > len(s.values[j]) })
+}
+
+// mask replaces the secrets in str
+func (s *secretSet) mask(str string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, secret := range s.values {
+		str = strings.ReplaceAll(str, secret, maskedValue)
+	}
+	return str
+}
+
+// maskedError masks the secrets in the message of the wrapped error, which
+// remains available to errors.As
+type maskedError struct {
+	err error
+	msg string
+}
+
+func (e *maskedError) Error() string {
+	return e.msg
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+// maskError returns err with the secrets masked in its message
+func maskError(err error, secrets *secretSet) error {
+	if err == nil {
+		return err
+	}
+	msg := secrets.mask(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &maskedError{err: err, msg: msg}
+}
+
+// maskingCore masks the secrets in the messages and string fields of the
+// entries written to the wrapped zapcore.Core
+type maskingCore struct {
+	zapcore.Core
+	secrets *secretSet
+}
+
+// maskLogger returns a logger masking the secrets in every log line
+func maskLogger(logger *zap.Logger, secrets *secretSet) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &maskingCore{Core: core, secrets: secrets}
+	}))
+}
+
+func (c *maskingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &maskingCore{Core: c.Core.With(c.maskFields(fields)), secrets: c.secrets}
+}
+
+func (c *maskingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *maskingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.secrets.mask(entry.Message)
+	entry.Stack = c.secrets.mask(entry.Stack)
+	return c.Core.Write(entry, c.maskFields(fields))
+}
+
+func (c *maskingCore) maskFields(fields []zapcore.Field) []zapcore.Field {
+	masked := make([]zapcore.Field, len(fields))
+	for idx, field := range fields {
+		switch {
+		case field.Type == zapcore.StringType:
+			field.String = c.secrets.mask(field.String)
+		case field.Type == zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field.Interface = maskError(err, c.secrets)
+			}
+		}
+		masked[idx] = field
+	}
+	return masked
+}
+
+// removeStaleFiles removes the files older than staleFileAge in dir, e.g.,
+// the config files of a process which crashed while a container was running
+func removeStaleFiles(logger *zap.Logger, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn(err.Error())
+		}
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || time.Since(info.ModTime()) < staleFileAge {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			logger.Warn(fmt.Sprintf("unable to remove stale file %s: %v", path, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("removed stale file %s", path))
+	}
+}
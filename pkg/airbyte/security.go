@@ -0,0 +1,115 @@
+package airbyte
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Network modes of a SecurityProfile
+const (
+	NetworkModeDefault = ""
+	NetworkModeNone    = "none"
+)
+
+// SeccompUnconfined disables the seccomp filtering of a SecurityProfile
+const SeccompUnconfined = "unconfined"
+
+// localDefinitionIDs are the destinations writing to the Airbyte volume
+var localDefinitionIDs = []string{
+	"airbyte-destination-local-json",
+	"airbyte-destination-csv",
+	"airbyte-destination-sqlite",
+	"airbyte-destination-duckdb",
+}
+
+// networkIsolatedDefinitionIDs are the destinations which never reach the
+// network, hence run with NetworkModeNone unless their profile is overridden.
+// DuckDB is not one of them since it may write to MotherDuck.
+var networkIsolatedDefinitionIDs = []string{
+	"airbyte-destination-local-json",
+	"airbyte-destination-csv",
+	"airbyte-destination-sqlite",
+}
+
+// SecurityProfile defines the privileges of the destination containers. The
+// zero value keeps the runtime defaults.
+type SecurityProfile struct {
+	// ReadOnlyRootFS mounts the container root filesystem read-only
+	ReadOnlyRootFS bool
+	// TmpfsPaths are mounted as tmpfs scratch directories, e.g., /tmp for the
+	// destinations writing temporary files with a read-only root filesystem
+	TmpfsPaths []string
+	// DropCapabilities are removed from the container, e.g., ALL
+	DropCapabilities []string
+	// NoNewPrivileges prevents the processes from gaining privileges, e.g.,
+	// through setuid binaries
+	NoNewPrivileges bool
+	// User runs the container as uid[:gid] instead of the image user
+	User string
+	// SeccompProfile is the path of a seccomp profile, SeccompUnconfined to
+	// disable seccomp, or empty for the runtime default. With Kubernetes, the
+	// path is relative to the kubelet seccomp directory.
+	SeccompProfile string
+	// NetworkMode is NetworkModeNone to run the container without network.
+	// Kubernetes has no such mode, the Pods are labelled instead so that a
+	// NetworkPolicy can isolate them.
+	NetworkMode string
+}
+
+// RestrictedSecurityProfile is a hardened profile suitable for most
+// destinations. With the Docker runtime, it requires the CopyJobFiles option
+// unless this process runs as its user, since the config files shared
+// through the VDP volume are readable by their owner only.
+var RestrictedSecurityProfile = SecurityProfile{
+	ReadOnlyRootFS:   true,
+	TmpfsPaths:       []string{"/tmp"},
+	DropCapabilities: []string{"ALL"},
+	NoNewPrivileges:  true,
+	User:             "1000:1000",
+}
+
+// checkSharedFileUsers returns an error if a profile runs the Docker
+// containers as another user than this process while the job files are
+// shared through the VDP volume, the containers being unable to read their
+// config file
+func checkSharedFileUsers(options ConnectorOptions) error {
+	if options.CopyJobFiles {
+		return nil
+	}
+	profiles := map[string]SecurityProfile{"": options.SecurityProfile}
+	for id, profile := range options.DefinitionSecurityProfiles {
+		profiles[id] = profile
+	}
+	for id, profile := range profiles {
+		if profile.User == "" {
+			continue
+		}
+		uid, _, _ := strings.Cut(profile.User, ":")
+		if uid == strconv.Itoa(os.Getuid()) {
+			continue
+		}
+		if id == "" {
+			return fmt.Errorf("the security profile user %s cannot read the job files shared through the VDP volume, set CopyJobFiles", profile.User)
+		}
+		return fmt.Errorf("the security profile user %s of %s cannot read the job files shared through the VDP volume, set CopyJobFiles", profile.User, id)
+	}
+	return nil
+}
+
+// securityProfile returns the profile of the definition: its override if
+// any, otherwise the connector profile, without network for the
+// network-isolated destinations
+func (c *Connector) securityProfile(def *connectorPB.ConnectorDefinition) SecurityProfile {
+	if profile, ok := c.options.DefinitionSecurityProfiles[def.GetId()]; ok {
+		return profile
+	}
+	profile := c.options.SecurityProfile
+	if contains(networkIsolatedDefinitionIDs, def.GetId()) {
+		profile.NetworkMode = NetworkModeNone
+	}
+	return profile
+}
@@ -0,0 +1,27 @@
+package airbyte
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCheckSharedFileUsers(t *testing.T) {
+	otherUser := fmt.Sprintf("%d:%d", os.Getuid()+1, os.Getgid())
+	tests := []struct {
+		name    string
+		options ConnectorOptions
+		wantErr bool
+	}{
+		{name: "default profile", options: ConnectorOptions{}},
+		{name: "user of this process", options: ConnectorOptions{SecurityProfile: SecurityProfile{User: fmt.Sprintf("%d", os.Getuid())}}},
+		{name: "other user", options: ConnectorOptions{SecurityProfile: SecurityProfile{User: otherUser}}, wantErr: true},
+		{name: "other user with copied files", options: ConnectorOptions{SecurityProfile: SecurityProfile{User: otherUser}, CopyJobFiles: true}},
+		{name: "other user of a definition", options: ConnectorOptions{DefinitionSecurityProfiles: map[string]SecurityProfile{"airbyte-destination-postgres": {User: otherUser}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := checkSharedFileUsers(tt.options); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkSharedFileUsers() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
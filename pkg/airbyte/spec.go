@@ -0,0 +1,228 @@
+package airbyte
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/instill-ai/connector/pkg/base"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// specVendorAttributes are the ConnectorSpecification fields stored in the
+// `spec` vendor attribute of the definitions, as in config/download.py
+var specVendorAttributes = []string{
+	"supported_destination_sync_modes",
+	"supportsIncremental",
+	"supportsNormalization",
+	"supportsDBT",
+	"authSpecification",
+	"advanced_auth",
+	"supportsNamespaces",
+	"protocol_version",
+}
+
+// RefreshSpec runs the `spec` command of the definition image and updates the
+// stored ConnectorDefinition with the returned ConnectorSpecification. The
+// specifications are cached per image digest, so that the command runs only
+// once per image. The stored definition is replaced by an updated copy, the
+// definitions returned before being left unchanged.
+func (c *Connector) RefreshSpec(defUid uuid.UUID) (*connectorPB.ConnectorDefinition, error) {
+
+	def, err := c.GetConnectorDefinitionByUid(defUid)
+	if err != nil {
+		return nil, err
+	}
+	imageName := c.imageName(def)
+
+	if err := c.pullImage(context.Background(), c.Logger, imageName); err != nil {
+		return nil, err
+	}
+
+	digest, err := c.runtime.ImageDigest(context.Background(), imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.specMu.Lock()
+	spec, ok := c.specs[digest]
+	c.specMu.Unlock()
+
+	if !ok || digest == "" {
+		err = retry(context.Background(), c.Logger, c.options.RetryPolicy, "spec", func() error {
+			spec, err = c.runSpec(defUid, imageName, def)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			c.specMu.Lock()
+			c.specs[digest] = spec
+			c.specMu.Unlock()
+		}
+	}
+
+	refreshed := proto.Clone(def).(*connectorPB.ConnectorDefinition)
+	if err := updateDefinitionSpec(refreshed, spec); err != nil {
+		return nil, fmt.Errorf("update %s spec error: %w", def.GetId(), err)
+	}
+	c.refreshedMu.Lock()
+	c.refreshed[defUid] = refreshed
+	c.refreshedMu.Unlock()
+	return refreshed, nil
+}
+
+// GetConnectorDefinitionByUid returns the definition, as refreshed by
+// RefreshSpec if it was
+func (c *Connector) GetConnectorDefinitionByUid(defUid uuid.UUID) (*connectorPB.ConnectorDefinition, error) {
+	c.refreshedMu.RLock()
+	def, ok := c.refreshed[defUid]
+	c.refreshedMu.RUnlock()
+	if ok {
+		return def, nil
+	}
+	return c.BaseConnector.GetConnectorDefinitionByUid(defUid)
+}
+
+// GetConnectorDefinitionById returns the definition, as refreshed by
+// RefreshSpec if it was
+func (c *Connector) GetConnectorDefinitionById(defId string) (*connectorPB.ConnectorDefinition, error) {
+	def, err := c.BaseConnector.GetConnectorDefinitionById(defId)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetConnectorDefinitionByUid(uuid.FromStringOrNil(def.GetUid()))
+}
+
+// GetConnectorDefinitionMap returns the definitions by uid, as refreshed by
+// RefreshSpec if they were
+func (c *Connector) GetConnectorDefinitionMap() map[uuid.UUID]*connectorPB.ConnectorDefinition {
+	defs := map[uuid.UUID]*connectorPB.ConnectorDefinition{}
+	for uid := range c.BaseConnector.GetConnectorDefinitionMap() {
+		defs[uid], _ = c.GetConnectorDefinitionByUid(uid)
+	}
+	return defs
+}
+
+// ListConnectorDefinitions returns the definitions, as refreshed by
+// RefreshSpec if they were
+func (c *Connector) ListConnectorDefinitions() []*connectorPB.ConnectorDefinition {
+	defs := []*connectorPB.ConnectorDefinition{}
+	for _, uid := range c.ListConnectorDefinitionUids() {
+		def, err := c.GetConnectorDefinitionByUid(uid)
+		if err != nil {
+			c.Logger.Error(err.Error())
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// ListCredentialField lists the credential fields of the definition spec, as
+// refreshed by RefreshSpec if it was
+func (c *Connector) ListCredentialField(defId string) []string {
+	def, err := c.GetConnectorDefinitionById(defId)
+	if err != nil {
+		return []string{}
+	}
+	// Reuse the listing of the base connector on the definition alone
+	b := base.BaseConnector{Logger: c.Logger}
+	if err := b.AddConnectorDefinition(uuid.FromStringOrNil(def.GetUid()), defId, def); err != nil {
+		return []string{}
+	}
+	return b.ListCredentialField(defId)
+}
+
+// IsCredentialField checks whether the field is a credential field of the
+// definition, see ListCredentialField
+func (c *Connector) IsCredentialField(defId string, target string) bool {
+	return contains(c.ListCredentialField(defId), target)
+}
+
+// runSpec runs the `spec` command and returns the ConnectorSpecification
+// with the `airbyte_secret` fields renamed to `credential_field`
+func (c *Connector) runSpec(defUid uuid.UUID, imageName string, def *connectorPB.ConnectorDefinition) (*ConnectorSpecification, error) {
+
+	containerName := fmt.Sprintf("%s.%d.spec", defUid, time.Now().UnixNano())
+
+	parser := newOutputParser(c.Logger, zap.String("ImageName", imageName), zap.String("ContainerName", containerName))
+	if err := c.runJob(context.Background(), def, jobTypeSpec, &ContainerJob{
+		Name:   containerName,
+		Image:  imageName,
+		Cmd:    []string{"spec"},
+		Stdout: parser,
+	}); err != nil {
+		return nil, err
+	}
+	parser.Close()
+
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	if parser.Spec == nil {
+		return nil, fmt.Errorf("no SPEC AirbyteMessage in %s output", imageName)
+	}
+
+	// Rename the whole specification as config/download.py does
+	b, err := json.Marshal(parser.Spec)
+	if err != nil {
+		return nil, err
+	}
+	spec := &ConnectorSpecification{}
+	if err := json.Unmarshal(bytes.ReplaceAll(b, []byte("airbyte_secret"), []byte("credential_field")), spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// updateDefinitionSpec sets the definition spec and the `spec` vendor
+// attribute from the ConnectorSpecification
+func updateDefinitionSpec(def *connectorPB.ConnectorDefinition, spec *ConnectorSpecification) error {
+
+	connectionSpecification := &structpb.Struct{}
+	if err := connectionSpecification.UnmarshalJSON(spec.ConnectionSpecification); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	specAttributes := map[string]interface{}{}
+	for _, key := range specVendorAttributes {
+		if value, ok := fields[key]; ok {
+			specAttributes[key] = value
+		}
+	}
+	specValue, err := structpb.NewValue(specAttributes)
+	if err != nil {
+		return err
+	}
+
+	if def.Spec == nil {
+		def.Spec = &connectorPB.Spec{}
+	}
+	def.Spec.ConnectionSpecification = connectionSpecification
+	if spec.DocumentationURL != "" {
+		def.Spec.DocumentationUrl = spec.DocumentationURL
+		def.DocumentationUrl = spec.DocumentationURL
+	}
+	if def.VendorAttributes == nil {
+		def.VendorAttributes = &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	}
+	def.VendorAttributes.Fields["spec"] = specValue
+	return nil
+}
@@ -0,0 +1,102 @@
+package airbyte
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// specRuntime returns the ConnectorSpecification of a postgres image whose
+// password is an airbyte_secret
+func specRuntime() *fakeRuntime {
+	return &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error {
+		return writeMessages(job.Stdout, AirbyteMessage{
+			Type: AirbyteMessageTypeSpec,
+			Spec: &ConnectorSpecification{
+				DocumentationURL: "https://docs.airbyte.com/integrations/destinations/postgres",
+				ConnectionSpecification: json.RawMessage(`{
+					"type": "object",
+					"required": ["host"],
+					"properties": {
+						"host": {"type": "string"},
+						"api_key": {"type": "string", "airbyte_secret": true}
+					}
+				}`),
+				SupportedDestinationSyncModes: []string{"append", "overwrite"},
+				SupportsNamespaces:            true,
+			},
+		})
+	}}
+}
+
+func TestRefreshSpec(t *testing.T) {
+	c := newTestConnector(t, specRuntime(), ConnectorOptions{})
+
+	before, err := c.GetConnectorDefinitionByUid(postgresDefUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeSpec := before.GetVendorAttributes().GetFields()["spec"].GetStructValue().AsMap()
+
+	refreshed, err := c.RefreshSpec(postgresDefUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed == before {
+		t.Fatalf("RefreshSpec updated the stored definition in place")
+	}
+	if got := before.GetVendorAttributes().GetFields()["spec"].GetStructValue().AsMap(); len(got) != len(beforeSpec) {
+		t.Errorf("spec of the previous definition = %v, want %v", got, beforeSpec)
+	}
+
+	stored, err := c.GetConnectorDefinitionByUid(postgresDefUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != refreshed {
+		t.Errorf("GetConnectorDefinitionByUid did not return the refreshed definition")
+	}
+	if byID, err := c.GetConnectorDefinitionById(refreshed.GetId()); err != nil || byID != refreshed {
+		t.Errorf("GetConnectorDefinitionById did not return the refreshed definition")
+	}
+
+	spec := refreshed.GetVendorAttributes().GetFields()["spec"].GetStructValue().AsMap()
+	if spec["supportsNamespaces"] != true {
+		t.Errorf("supportsNamespaces = %v, want true", spec["supportsNamespaces"])
+	}
+	if got := c.ListCredentialField(refreshed.GetId()); len(got) != 1 || got[0] != "api_key" {
+		t.Errorf("ListCredentialField() = %v, want [api_key]", got)
+	}
+	if !c.IsCredentialField(refreshed.GetId(), "api_key") {
+		t.Errorf("IsCredentialField(api_key) = false")
+	}
+}
+
+// TestRefreshSpecConcurrentReads is meaningful with the race detector
+func TestRefreshSpecConcurrentReads(t *testing.T) {
+	c := newTestConnector(t, specRuntime(), ConnectorOptions{})
+	config, err := structpb.NewStruct(map[string]interface{}{"host": "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := c.RefreshSpec(postgresDefUID); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = c.CreateConnection(postgresDefUID, config, zap.NewNop())
+			_ = c.ListConnectorDefinitions()
+		}()
+	}
+	wg.Wait()
+}
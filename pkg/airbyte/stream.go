@@ -0,0 +1,168 @@
+package airbyte
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// DataPayloadIterator iterates over the DataPayloads written to a destination
+type DataPayloadIterator interface {
+	// Next returns the next DataPayload, or io.EOF when there is none left
+	Next() (*connectorPB.DataPayload, error)
+}
+
+type sliceIterator struct {
+	payloads []*connectorPB.DataPayload
+	idx      int
+}
+
+// NewSliceIterator returns a DataPayloadIterator over a slice of DataPayloads
+func NewSliceIterator(payloads []*connectorPB.DataPayload) DataPayloadIterator {
+	return &sliceIterator{payloads: payloads}
+}
+
+func (it *sliceIterator) Next() (*connectorPB.DataPayload, error) {
+	if it.idx >= len(it.payloads) {
+		return nil, io.EOF
+	}
+	it.idx++
+	return it.payloads[it.idx-1], nil
+}
+
+type channelIterator struct {
+	ch <-chan *connectorPB.DataPayload
+}
+
+// NewChannelIterator returns a DataPayloadIterator reading the DataPayloads
+// from a channel until it is closed
+func NewChannelIterator(ch <-chan *connectorPB.DataPayload) DataPayloadIterator {
+	return &channelIterator{ch: ch}
+}
+
+func (it *channelIterator) Next() (*connectorPB.DataPayload, error) {
+	dataPayload, ok := <-it.ch
+	if !ok {
+		return nil, io.EOF
+	}
+	return dataPayload, nil
+}
+
+// prependIterator yields first before the DataPayloads of the iterator
+type prependIterator struct {
+	first *connectorPB.DataPayload
+	it    DataPayloadIterator
+}
+
+func (it *prependIterator) Next() (*connectorPB.DataPayload, error) {
+	if it.first != nil {
+		first := it.first
+		it.first = nil
+		return first, nil
+	}
+	return it.it.Next()
+}
+
+// writeRecords builds the records of each DataPayload, wraps them into
+// AirbyteMessage RECORDs and writes them to w in JSON Lines format, one
+// message at a time. Records of a stream not in streams are rejected. The
+// DataPayloads failing the validation are handled according to the
+// validation policy: reject_batch stops the write with the ValidationErrors,
+// skip_record does not write them and quarantine writes them to the
+// quarantine stream. It returns the number of DataPayloads written to their
+// task streams and the validation errors of the others. The images of the
+// valid DataPayloads are handled by images once validated.
+func writeRecords(ctx context.Context, w io.Writer, it DataPayloadIterator, streams []string, options connectionOptions, images *imageHandler) (int64, ValidationErrors, error) {
+
+	encoder := json.NewEncoder(w)
+
+	var written int64
+	invalid := ValidationErrors{}
+	for idx := int64(0); ; idx++ {
+		dataPayload, err := it.Next()
+		if err == io.EOF {
+			return written, invalid, nil
+		}
+		if err != nil {
+			return written, invalid, err
+		}
+
+		records := buildRecords(dataPayload, options.FlattenObjects, nil)
+		fieldErrors, err := validatePayload(dataPayload, records)
+		if err != nil {
+			return written, invalid, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+		}
+		if len(fieldErrors) > 0 {
+			validationErr := &ValidationError{
+				Index:            idx,
+				DataMappingIndex: dataPayload.GetDataMappingIndex(),
+				Errors:           fieldErrors,
+			}
+			invalid = append(invalid, validationErr)
+			switch options.ValidationPolicy {
+			case validationPolicySkipRecord:
+				continue
+			case validationPolicyQuarantine:
+				record, err := quarantineRecord(dataPayload, fieldErrors)
+				if err != nil {
+					return written, invalid, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+				}
+				records = []taskRecord{record}
+			default:
+				return written, invalid, invalid
+			}
+		} else {
+			dataPayload, payloadImages, err := images.handlePayload(ctx, dataPayload)
+			if err != nil {
+				return written, invalid, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+			}
+			records = buildRecords(dataPayload, options.FlattenObjects, payloadImages)
+			written++
+		}
+
+		for _, record := range records {
+			if !contains(streams, record.Stream) {
+				return written, invalid, fmt.Errorf("DataPayload [%d] error: %s output is not in the catalog streams %v", idx, record.Stream, streams)
+			}
+			b, err := record.marshal()
+			if err != nil {
+				return written, invalid, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+			}
+
+			abMsg := AirbyteMessage{}
+			abMsg.Type = AirbyteMessageTypeRecord
+			abMsg.Record = &AirbyteRecordMessage{
+				Stream:    record.Stream,
+				Data:      b,
+				EmittedAt: time.Now().UnixMilli(),
+			}
+			if err := encoder.Encode(&abMsg); err != nil {
+				return written, invalid, fmt.Errorf("write AirbyteMessage error: %w", err)
+			}
+		}
+	}
+}
+
+// validateRecords validates all the DataPayloads of a batch without writing
+// them, so that a batch can be rejected before a container is started
+func validateRecords(payloads []*connectorPB.DataPayload, flattenObjects bool) (ValidationErrors, error) {
+	invalid := ValidationErrors{}
+	for idx, dataPayload := range payloads {
+		fieldErrors, err := validatePayload(dataPayload, buildRecords(dataPayload, flattenObjects, nil))
+		if err != nil {
+			return nil, fmt.Errorf("DataPayload [%d] error: %w", idx, err)
+		}
+		if len(fieldErrors) > 0 {
+			invalid = append(invalid, &ValidationError{
+				Index:            int64(idx),
+				DataMappingIndex: dataPayload.GetDataMappingIndex(),
+				Errors:           fieldErrors,
+			})
+		}
+	}
+	return invalid, nil
+}
@@ -0,0 +1,99 @@
+package airbyte
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// JobTimeouts bounds the duration of the containers per job type, from their
+// creation to their removal
+type JobTimeouts struct {
+	Spec  time.Duration
+	Check time.Duration
+	Write time.Duration
+}
+
+// DefaultJobTimeouts is used for the zero JobTimeouts fields
+var DefaultJobTimeouts = JobTimeouts{
+	Spec:  5 * time.Minute,
+	Check: 5 * time.Minute,
+	Write: time.Hour,
+}
+
+// forJobType returns the timeout of the job type, zero if not set
+func (t JobTimeouts) forJobType(jobType string) time.Duration {
+	switch jobType {
+	case jobTypeSpec:
+		return t.Spec
+	case jobTypeCheck:
+		return t.Check
+	case jobTypeSync:
+		return t.Write
+	}
+	return 0
+}
+
+// DeadlineError is returned when a container is killed because its job
+// timeout, or the deadline of the caller context, passed
+type DeadlineError struct {
+	JobType   string
+	Image     string
+	Container string
+	Timeout   time.Duration
+	Err       error
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("%s container %s of image %s killed after exceeding its deadline (timeout %s): %v", e.JobType, e.Container, e.Image, e.Timeout, e.Err)
+}
+
+// Unwrap returns context.DeadlineExceeded along with the runtime error, so
+// that errors.Is(err, context.DeadlineExceeded) holds
+func (e *DeadlineError) Unwrap() []error {
+	return []error{context.DeadlineExceeded, e.Err}
+}
+
+// jobTimeout returns the timeout of the definition job, the definition
+// timeouts overriding the connector ones
+func (c *Connector) jobTimeout(def *connectorPB.ConnectorDefinition, jobType string) time.Duration {
+	if timeout := c.options.DefinitionTimeouts[def.GetId()].forJobType(jobType); timeout > 0 {
+		return timeout
+	}
+	if timeout := c.options.Timeouts.forJobType(jobType); timeout > 0 {
+		return timeout
+	}
+	return DefaultJobTimeouts.forJobType(jobType)
+}
+
+// runJob runs the job with the resources, the security profile and within
+// the timeout of the definition job type. The runtimes kill and remove the container once the
+// context is done.
+func (c *Connector) runJob(ctx context.Context, def *connectorPB.ConnectorDefinition, jobType string, job *ContainerJob) error {
+
+	resources, err := c.jobResources(def, jobType)
+	if err != nil {
+		return err
+	}
+	env, err := javaOptions(resources)
+	if err != nil {
+		return err
+	}
+	job.Resources = resources
+	job.Env = append(job.Env, env...)
+	job.Security = c.securityProfile(def)
+	job.PullPolicy = c.pullPolicy()
+
+	timeout := c.jobTimeout(def, jobType)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err = c.runtime.Run(runCtx, job)
+	if err != nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return &DeadlineError{JobType: jobType, Image: job.Image, Container: job.Name, Timeout: timeout, Err: err}
+	}
+	return err
+}
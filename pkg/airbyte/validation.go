@@ -0,0 +1,262 @@
+package airbyte
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// Validation policies applied to the DataPayloads failing the validation
+const (
+	// validationPolicyRejectBatch fails the whole write
+	validationPolicyRejectBatch = "reject_batch"
+	// validationPolicySkipRecord does not write the DataPayload
+	validationPolicySkipRecord = "skip_record"
+	// validationPolicyQuarantine writes the DataPayload to the quarantine stream
+	validationPolicyQuarantine = "quarantine"
+)
+
+// quarantineStreamName is the stream holding the DataPayloads failing the
+// validation with the quarantine policy
+const quarantineStreamName = "quarantine"
+
+const quarantineStreamSchema = `
+{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"description": "DataPayloads failing the VDP protocol validation",
+	"properties": {
+		"data_mapping_index": {
+			"type": "string"
+		},
+		"payload": {
+			"description": "The DataPayload, without images",
+			"type": "object"
+		},
+		"errors": {
+			"description": "The validation errors",
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"stream": {
+						"type": "string"
+					},
+					"instance_location": {
+						"type": "string"
+					},
+					"message": {
+						"type": "string"
+					}
+				}
+			}
+		}
+	},
+	"required": ["data_mapping_index", "payload", "errors"]
+}
+`
+
+// dataPayloadSchema validates the marshalled DataPayloads
+var dataPayloadSchema *jsonschema.Schema
+
+// streamSchemas validates the records of each TaskOutputAirbyteCatalog stream
+var streamSchemas map[string]*jsonschema.Schema
+
+// FieldError is a validation error of a DataPayload field
+type FieldError struct {
+	// Stream is the stream of the failing record, empty if the error is
+	// about the DataPayload itself
+	Stream string `json:"stream,omitempty"`
+	// InstanceLocation is the JSON pointer of the failing field
+	InstanceLocation string `json:"instance_location"`
+	Message          string `json:"message"`
+}
+
+// ValidationError holds the validation errors of a DataPayload
+type ValidationError struct {
+	Index            int64
+	DataMappingIndex string
+	Errors           []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, fieldErr := range e.Errors {
+		location := fieldErr.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		if fieldErr.Stream != "" {
+			location = fmt.Sprintf("%s#%s", fieldErr.Stream, location)
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", location, fieldErr.Message))
+	}
+	return fmt.Sprintf("DataPayload [%d] (data_mapping_index %s) is invalid: %s", e.Index, e.DataMappingIndex, strings.Join(msgs, "; "))
+}
+
+// ValidationErrors holds the validation errors of several DataPayloads
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// compileSchemas compiles the DataPayload schema and the stream schemas
+func compileSchemas() error {
+
+	compiler := jsonschema.NewCompiler()
+
+	if err := compiler.AddResource("protocol.json", strings.NewReader(dataSchema)); err != nil {
+		return err
+	}
+	schema, err := compiler.Compile("protocol.json")
+	if err != nil {
+		return err
+	}
+	dataPayloadSchema = schema
+
+	streamSchemas = map[string]*jsonschema.Schema{}
+	for _, stream := range TaskOutputAirbyteCatalog.Streams {
+		url := fmt.Sprintf("%s.json", stream.Name)
+		if err := compiler.AddResource(url, bytes.NewReader(stream.JSONSchema)); err != nil {
+			return err
+		}
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return err
+		}
+		streamSchemas[stream.Name] = schema
+	}
+	return nil
+}
+
+// validatePayload validates the marshalled DataPayload against the
+// DataPayload schema and each of its records against its stream schema
+func validatePayload(dataPayload *connectorPB.DataPayload, records []taskRecord) ([]FieldError, error) {
+
+	fieldErrors := []FieldError{}
+
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(dataPayload)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if dataPayloadSchema != nil {
+		fieldErrors = append(fieldErrors, schemaErrors(dataPayloadSchema.Validate(v), "")...)
+	}
+
+	for _, record := range records {
+		schema, ok := streamSchemas[record.Stream]
+		if !ok {
+			continue
+		}
+		// Structured data without task output is written in the free form
+		if record.Stream == taskUnspecified && dataPayload.GetStructuredData().GetFields()[taskUnspecified] == nil {
+			continue
+		}
+		b, err := record.marshal()
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		fieldErrors = append(fieldErrors, schemaErrors(schema.Validate(v), record.Stream)...)
+	}
+	return fieldErrors, nil
+}
+
+// schemaErrors returns the leaf errors of a jsonschema validation error
+func schemaErrors(err error, stream string) []FieldError {
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		if err != nil {
+			return []FieldError{{Stream: stream, Message: err.Error()}}
+		}
+		return nil
+	}
+
+	fieldErrors := []FieldError{}
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			fieldErrors = append(fieldErrors, FieldError{
+				Stream:           stream,
+				InstanceLocation: e.InstanceLocation,
+				Message:          e.Message,
+			})
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(validationErr)
+	return fieldErrors
+}
+
+// quarantineRecord builds the record of a DataPayload failing the validation
+func quarantineRecord(dataPayload *connectorPB.DataPayload, fieldErrors []FieldError) (taskRecord, error) {
+
+	payload := proto.Clone(dataPayload).(*connectorPB.DataPayload)
+	payload.Images = nil
+
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(payload)
+	if err != nil {
+		return taskRecord{}, err
+	}
+	var payloadData map[string]interface{}
+	if err := json.Unmarshal(b, &payloadData); err != nil {
+		return taskRecord{}, err
+	}
+
+	errors := []interface{}{}
+	for _, fieldErr := range fieldErrors {
+		errors = append(errors, map[string]interface{}{
+			"stream":            fieldErr.Stream,
+			"instance_location": fieldErr.InstanceLocation,
+			"message":           fieldErr.Message,
+		})
+	}
+
+	return taskRecord{
+		Stream: quarantineStreamName,
+		Data: map[string]interface{}{
+			"data_mapping_index": dataPayload.GetDataMappingIndex(),
+			"payload":            payloadData,
+			"errors":             errors,
+		},
+	}, nil
+}
+
+// validationErrorMetadata returns the output metadata holding the validation
+// errors of a DataPayload
+func validationErrorMetadata(validationErr *ValidationError) (*structpb.Struct, error) {
+	errors := []interface{}{}
+	for _, fieldErr := range validationErr.Errors {
+		errors = append(errors, map[string]interface{}{
+			"stream":            fieldErr.Stream,
+			"instance_location": fieldErr.InstanceLocation,
+			"message":           fieldErr.Message,
+		})
+	}
+	return structpb.NewStruct(map[string]interface{}{
+		"validation_errors": errors,
+	})
+}
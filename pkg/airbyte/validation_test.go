@@ -0,0 +1,150 @@
+package airbyte
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// invalidClassificationOutput does not match the classification schema
+var invalidClassificationOutput = map[string]interface{}{
+	"classification": map[string]interface{}{"category": "cat", "score": "high"},
+}
+
+// validationInputs returns a batch whose second DataPayload is invalid
+func validationInputs(t *testing.T) []*connectorPB.DataPayload {
+	t.Helper()
+	return []*connectorPB.DataPayload{
+		testDataPayload(t, "01", classificationOutput),
+		testDataPayload(t, "02", invalidClassificationOutput),
+		testDataPayload(t, "03", classificationOutput),
+	}
+}
+
+// assertValidationError checks the ValidationError names the DataPayload
+// index and the failing JSON pointer
+func assertValidationError(t *testing.T, validationErr *ValidationError) {
+	t.Helper()
+	if validationErr.Index != 1 || validationErr.DataMappingIndex != "02" {
+		t.Errorf("ValidationError = %v, want the DataPayload [1]", validationErr)
+	}
+	if len(validationErr.Errors) == 0 || validationErr.Errors[0].Stream != "classification" || validationErr.Errors[0].InstanceLocation != "/score" {
+		t.Errorf("ValidationError errors = %+v, want the classification /score error", validationErr.Errors)
+	}
+}
+
+func TestExecuteRejectBatch(t *testing.T) {
+	// reject_batch is the default policy
+	for _, options := range []map[string]interface{}{nil, {"validation_policy": "reject_batch"}} {
+		runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error { return nil }}
+		con := newTestConnectionWithOptions(t, runtime, options)
+
+		_, err := con.Execute(validationInputs(t))
+		var invalid ValidationErrors
+		if !errors.As(err, &invalid) || len(invalid) != 1 {
+			t.Fatalf("Execute() error = %v, want the ValidationErrors of one DataPayload", err)
+		}
+		assertValidationError(t, invalid[0])
+		// The batch is rejected before any container is started
+		if runs := len(runtime.Runs()); runs != 0 {
+			t.Errorf("runs = %d, want 0", runs)
+		}
+	}
+}
+
+func TestExecuteSkipRecord(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error { return nil }}
+	con := newTestConnectionWithOptions(t, runtime, map[string]interface{}{"validation_policy": "skip_record"})
+
+	outputs, err := con.Execute(validationInputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := records(t, runtime.Runs()[0].Stdin)
+	if len(got) != 2 {
+		t.Fatalf("records = %v, want the 2 valid records", got)
+	}
+	for _, record := range got {
+		var data map[string]interface{}
+		if err := json.Unmarshal(record.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data["data_mapping_index"] == "02" {
+			t.Errorf("record = %v, want the invalid DataPayload skipped", data)
+		}
+	}
+	assertValidationErrorMetadata(t, outputs)
+}
+
+func TestExecuteQuarantine(t *testing.T) {
+	runtime := &fakeRuntime{run: func(job *ContainerJob, stdin []byte) error { return nil }}
+	con := newTestConnectionWithOptions(t, runtime, map[string]interface{}{"validation_policy": "quarantine"})
+
+	outputs, err := con.Execute(validationInputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := records(t, runtime.Runs()[0].Stdin)
+	if len(got) != 3 || got[1].Stream != quarantineStreamName {
+		t.Fatalf("records = %v, want the invalid DataPayload quarantined", got)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(got[1].Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	errs, _ := data["errors"].([]interface{})
+	if data["data_mapping_index"] != "02" || data["payload"] == nil || len(errs) == 0 {
+		t.Errorf("quarantine record = %v, want the DataPayload and its errors", data)
+	}
+
+	// The quarantine stream is configured along the task streams
+	var catalog ConfiguredAirbyteCatalog
+	if err := json.Unmarshal(runtime.Runs()[0].Job.Files[1].Content, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	configured := false
+	for _, stream := range catalog.Streams {
+		configured = configured || stream.Stream.Name == quarantineStreamName
+	}
+	if !configured {
+		t.Errorf("catalog streams = %v, want the quarantine stream", catalog.Streams)
+	}
+	assertValidationErrorMetadata(t, outputs)
+}
+
+// assertValidationErrorMetadata checks the output of the invalid DataPayload
+// holds its validation errors, the others none
+func assertValidationErrorMetadata(t *testing.T, outputs []*connectorPB.DataPayload) {
+	t.Helper()
+	if len(outputs) != 3 {
+		t.Fatalf("outputs = %v, want one per input", outputs)
+	}
+	for idx, output := range outputs {
+		validationErrors := output.GetMetadata().GetFields()["validation_errors"].GetListValue().GetValues()
+		if idx == 1 {
+			if len(validationErrors) == 0 || validationErrors[0].GetStructValue().GetFields()["instance_location"].GetStringValue() != "/score" {
+				t.Errorf("output [1] metadata = %v, want the /score validation error", output.GetMetadata())
+			}
+			continue
+		}
+		if output.GetMetadata() != nil {
+			t.Errorf("output [%d] metadata = %v, want none", idx, output.GetMetadata())
+		}
+	}
+}
+
+func TestExecuteStreamRejectBatch(t *testing.T) {
+	con := newTestConnection(t, &inputFailureRuntime{fakeRuntime: &fakeRuntime{}})
+
+	// The invalid DataPayload aborts the write already started
+	_, err := con.ExecuteStream(NewSliceIterator(validationInputs(t)))
+	var invalid ValidationErrors
+	if !errors.As(err, &invalid) || len(invalid) != 1 {
+		t.Fatalf("ExecuteStream() error = %v, want the ValidationErrors of one DataPayload", err)
+	}
+	assertValidationError(t, invalid[0])
+}
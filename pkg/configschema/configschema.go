@@ -0,0 +1,210 @@
+// Package configschema validates the connection configs against the
+// connectionSpecification of their ConnectorDefinition
+package configschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// schemas caches the compiled connectionSpecifications. A refreshed spec is
+// a new *structpb.Struct, hence it is compiled again.
+var schemas sync.Map
+
+// FieldError is a validation error of a config field
+type FieldError struct {
+	// Path is the dot-separated path of the field, e.g., credentials.password,
+	// empty for the config itself
+	Path string `json:"path"`
+	// Keyword is the failing JSON schema keyword, e.g., required
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// ConfigError is returned for a config not matching the connectionSpecification
+type ConfigError struct {
+	DefinitionID string
+	Errors       []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	msgs := []string{}
+	for _, fieldErr := range e.Errors {
+		path := fieldErr.Path
+		if path == "" {
+			path = "config"
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", path, fieldErr.Message))
+	}
+	return fmt.Sprintf("invalid %s config: %s", e.DefinitionID, strings.Join(msgs, "; "))
+}
+
+// Validate validates the config against the definition connectionSpecification
+// and returns a *ConfigError naming each offending field
+func Validate(def *connectorPB.ConnectorDefinition, config *structpb.Struct) error {
+
+	connectionSpecification := def.GetSpec().GetConnectionSpecification()
+	if connectionSpecification == nil {
+		return nil
+	}
+
+	schema, err := compile(connectionSpecification)
+	if err != nil {
+		return fmt.Errorf("compile %s connectionSpecification error: %w", def.GetId(), err)
+	}
+
+	var v interface{} = map[string]interface{}{}
+	if config != nil {
+		b, err := config.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+	}
+
+	validationErr, ok := schema.Validate(v).(*jsonschema.ValidationError)
+	if !ok {
+		return nil
+	}
+
+	configErr := &ConfigError{DefinitionID: def.GetId()}
+	// Branches may report the same error through several keywords, e.g.,
+	// const and enum
+	seen := map[string]bool{}
+	add := func(fieldErr FieldError) {
+		key := fieldErr.Path + "\x00" + fieldErr.Message
+		if !seen[key] {
+			seen[key] = true
+			configErr.Errors = append(configErr.Errors, fieldErr)
+		}
+	}
+
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			// Name the missing or unexpected fields rather than their parent
+			properties := quotedNames(e.Message)
+			switch kw := keyword(e.KeywordLocation); {
+			case kw == "required" && len(properties) > 0:
+				for _, property := range properties {
+					add(FieldError{Path: joinPath(fieldPath(e.InstanceLocation), property), Keyword: kw, Message: "missing required field"})
+				}
+			case kw == "additionalProperties" && len(properties) > 0:
+				for _, property := range properties {
+					add(FieldError{Path: joinPath(fieldPath(e.InstanceLocation), property), Keyword: kw, Message: "unknown field"})
+				}
+			default:
+				add(FieldError{Path: fieldPath(e.InstanceLocation), Keyword: kw, Message: e.Message})
+			}
+			return
+		}
+		causes := e.Causes
+		if keyword(e.KeywordLocation) == "oneOf" {
+			causes = selectedBranches(causes)
+		}
+		for _, cause := range causes {
+			walk(cause)
+		}
+	}
+	walk(validationErr)
+	return configErr
+}
+
+func compile(connectionSpecification *structpb.Struct) (*jsonschema.Schema, error) {
+
+	if schema, ok := schemas.Load(connectionSpecification); ok {
+		return schema.(*jsonschema.Schema), nil
+	}
+
+	b, err := connectionSpecification.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	if err := compiler.AddResource("spec.json", strings.NewReader(string(b))); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile("spec.json")
+	if err != nil {
+		return nil, err
+	}
+	schemas.Store(connectionSpecification, schema)
+	return schema, nil
+}
+
+// fieldPath converts a JSON pointer, e.g., /credentials/password, into a
+// dot-separated field path as used for the credential fields
+func fieldPath(pointer string) string {
+	if pointer == "" {
+		return ""
+	}
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for idx, token := range tokens {
+		tokens[idx] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return strings.Join(tokens, ".")
+}
+
+// keyword returns the last keyword of a keyword location, e.g., required
+func keyword(keywordLocation string) string {
+	return keywordLocation[strings.LastIndex(keywordLocation, "/")+1:]
+}
+
+// quotedNames returns the property names of a required or additionalProperties
+// error message, e.g., missing properties: 'host', 'port'
+func quotedNames(message string) []string {
+	properties := []string{}
+	parts := strings.Split(message, "'")
+	for idx := 1; idx < len(parts); idx += 2 {
+		properties = append(properties, parts[idx])
+	}
+	return properties
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// selectedBranches returns the errors of the oneOf branch selected by its
+// const or enum discriminator, e.g., the ssl_mode mode, so that the errors
+// of the other branches are not reported. All the branches are returned if
+// none or several of them are selected.
+func selectedBranches(branches []*jsonschema.ValidationError) []*jsonschema.ValidationError {
+	selected := []*jsonschema.ValidationError{}
+	for _, branch := range branches {
+		if !hasDiscriminatorError(branch) {
+			selected = append(selected, branch)
+		}
+	}
+	if len(selected) != 1 {
+		return branches
+	}
+	return selected
+}
+
+func hasDiscriminatorError(e *jsonschema.ValidationError) bool {
+	if len(e.Causes) == 0 {
+		kw := keyword(e.KeywordLocation)
+		return kw == "const" || kw == "enum"
+	}
+	for _, cause := range e.Causes {
+		if hasDiscriminatorError(cause) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package configschema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+
+	connectorPB "github.com/instill-ai/protogen-go/vdp/connector/v1alpha"
+)
+
+// seedDefinition returns the seed definition with its connectionSpecification
+func seedDefinition(t *testing.T, id string) *connectorPB.ConnectorDefinition {
+	t.Helper()
+	b, err := os.ReadFile("../airbyte/config/seed/definitions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seeds []struct {
+		ID   string `json:"id"`
+		Spec struct {
+			ConnectionSpecification json.RawMessage `json:"connectionSpecification"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(b, &seeds); err != nil {
+		t.Fatal(err)
+	}
+	for _, seed := range seeds {
+		if seed.ID != id {
+			continue
+		}
+		connectionSpecification := &structpb.Struct{}
+		if err := connectionSpecification.UnmarshalJSON(seed.Spec.ConnectionSpecification); err != nil {
+			t.Fatal(err)
+		}
+		return &connectorPB.ConnectorDefinition{
+			Id:   id,
+			Spec: &connectorPB.Spec{ConnectionSpecification: connectionSpecification},
+		}
+	}
+	t.Fatalf("no %s seed definition", id)
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	def := seedDefinition(t, "airbyte-destination-postgres")
+	valid := func(fields map[string]interface{}) map[string]interface{} {
+		config := map[string]interface{}{
+			"host":     "localhost",
+			"port":     5432,
+			"username": "vdp",
+			"database": "vdp",
+			"schema":   "public",
+		}
+		for key, value := range fields {
+			if value == nil {
+				delete(config, key)
+				continue
+			}
+			config[key] = value
+		}
+		return config
+	}
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		want   []FieldError
+	}{
+		{
+			name:   "valid",
+			config: valid(map[string]interface{}{"ssl_mode": map[string]interface{}{"mode": "verify-ca", "ca_certificate": "cert"}}),
+		},
+		{
+			name:   "missing host",
+			config: valid(map[string]interface{}{"host": nil}),
+			want:   []FieldError{{Path: "host", Keyword: "required"}},
+		},
+		{
+			name:   "missing host and port",
+			config: valid(map[string]interface{}{"host": nil, "port": nil}),
+			want:   []FieldError{{Path: "host", Keyword: "required"}, {Path: "port", Keyword: "required"}},
+		},
+		{
+			name:   "wrong port type",
+			config: valid(map[string]interface{}{"port": "5432"}),
+			want:   []FieldError{{Path: "port", Keyword: "type"}},
+		},
+		{
+			// The errors of the branches other than verify-ca are not reported
+			name:   "ssl_mode branch missing field",
+			config: valid(map[string]interface{}{"ssl_mode": map[string]interface{}{"mode": "verify-ca"}}),
+			want:   []FieldError{{Path: "ssl_mode.ca_certificate", Keyword: "required"}},
+		},
+		{
+			name:   "ssl_mode branch unknown field",
+			config: valid(map[string]interface{}{"ssl_mode": map[string]interface{}{"mode": "disable", "ca_certificate": "cert"}}),
+			want:   []FieldError{{Path: "ssl_mode.ca_certificate", Keyword: "additionalProperties"}},
+		},
+	}
+	for _, tt := range tests {
+		config, err := structpb.NewStruct(tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Validate(def, config)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: Validate() error = %v, want none", tt.name, err)
+			}
+			continue
+		}
+
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("%s: Validate() error = %v, want a *ConfigError", tt.name, err)
+			continue
+		}
+		got := []FieldError{}
+		for _, fieldErr := range configErr.Errors {
+			got = append(got, FieldError{Path: fieldErr.Path, Keyword: fieldErr.Keyword})
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].Path < got[j].Path })
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Validate() errors = %v, want %v", tt.name, configErr.Errors, tt.want)
+		}
+	}
+}
+
+func TestValidateUnknownBranch(t *testing.T) {
+	def := seedDefinition(t, "airbyte-destination-postgres")
+	config, err := structpb.NewStruct(map[string]interface{}{
+		"host":     "localhost",
+		"port":     5432,
+		"username": "vdp",
+		"database": "vdp",
+		"schema":   "public",
+		"ssl_mode": map[string]interface{}{"mode": "unknown"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No branch is selected, hence the errors of all of them are reported
+	err = Validate(def, config)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Validate() error = %v, want a *ConfigError", err)
+	}
+	modeErrors := 0
+	for _, fieldErr := range configErr.Errors {
+		if !strings.HasPrefix(fieldErr.Path, "ssl_mode.") {
+			t.Errorf("field error = %+v, want an ssl_mode error", fieldErr)
+		}
+		if fieldErr.Path == "ssl_mode.mode" {
+			modeErrors++
+		}
+	}
+	if modeErrors < 2 {
+		t.Errorf("field errors = %v, want the mode errors of several branches", configErr.Errors)
+	}
+}
+
+func TestQuotedNames(t *testing.T) {
+	tests := map[string][]string{
+		"missing properties: 'host', 'port'":                {"host", "port"},
+		"additionalProperties 'ca_certificate' not allowed": {"ca_certificate"},
+		"expected integer, but got string":                  {},
+	}
+	for message, want := range tests {
+		if got := quotedNames(message); !reflect.DeepEqual(got, want) {
+			t.Errorf("quotedNames(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"/host":                  "host",
+		"/credentials/password":  "credentials.password",
+		"/headers/content~1type": "headers.content/type",
+		"/tilde~0name":           "tilde~name",
+	}
+	for pointer, want := range tests {
+		if got := fieldPath(pointer); got != want {
+			t.Errorf("fieldPath(%q) = %q, want %q", pointer, got, want)
+		}
+	}
+}
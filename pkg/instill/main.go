@@ -9,6 +9,7 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/types/known/structpb"
 
+	"github.com/instill-ai/connector-destination/pkg/configschema"
 	"github.com/instill-ai/connector/pkg/base"
 	"github.com/instill-ai/connector/pkg/configLoader"
 
@@ -31,7 +32,9 @@ type Connector struct {
 
 type Connection struct {
 	base.BaseConnection
-	config *structpb.Struct
+	connector *Connector
+	defUid    uuid.UUID
+	config    *structpb.Struct
 }
 
 func Init(logger *zap.Logger) base.IConnector {
@@ -57,17 +60,43 @@ func Init(logger *zap.Logger) base.IConnector {
 }
 
 func (c *Connector) CreateConnection(defUid uuid.UUID, config *structpb.Struct, logger *zap.Logger) (base.IConnection, error) {
+
+	def, err := c.GetConnectorDefinitionByUid(defUid)
+	if err != nil {
+		return nil, err
+	}
+	if err := configschema.Validate(def, config); err != nil {
+		return nil, err
+	}
+
 	return &Connection{
 		BaseConnection: base.BaseConnection{Logger: logger},
+		connector:      c,
+		defUid:         defUid,
 		config:         config,
 	}, nil
 }
 
+// validateConfig validates the config against the definition spec
+func (con *Connection) validateConfig() error {
+	def, err := con.connector.GetConnectorDefinitionByUid(con.defUid)
+	if err != nil {
+		return err
+	}
+	return configschema.Validate(def, con.config)
+}
+
 func (con *Connection) Execute(inputs []*connectorPB.DataPayload) ([]*connectorPB.DataPayload, error) {
+	if err := con.validateConfig(); err != nil {
+		return nil, err
+	}
 	return inputs, nil
 }
 
 func (con *Connection) Test() (connectorPB.Connector_State, error) {
+	if err := con.validateConfig(); err != nil {
+		return connectorPB.Connector_STATE_ERROR, err
+	}
 	// Always connected
 	return connectorPB.Connector_STATE_CONNECTED, nil
 }